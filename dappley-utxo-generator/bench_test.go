@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+//size of the synthetic chain, eg. go test -run none -bench . -synth.blocks 10000 -synth.pubkeys 100000
+//for 1M utxos
+var (
+	synthBlocks  = flag.Uint64("synth.blocks", 100, "number of blocks of the synthetic chain")
+	synthTxs     = flag.Uint64("synth.txs", 10, "number of transactions in each block")
+	synthVouts   = flag.Uint64("synth.vouts", 10, "number of outputs of each transaction")
+	synthPubkeys = flag.Uint64("synth.pubkeys", 1000, "number of pubkeys the outputs are spread over")
+)
+
+func benchSynthConfig() synthConfig {
+	return synthConfig{
+		blocks:    *synthBlocks,
+		txs:       *synthTxs,
+		vouts:     *synthVouts,
+		pubkeys:   *synthPubkeys,
+		withOldV3: true,
+	}
+}
+
+//a new database with the synthetic chain, removed by the returned func
+func newSynthDB(b *testing.B, cfg synthConfig) (*levelStorage, func()) {
+	dir, err := ioutil.TempDir("", "utxo_generator_bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	db, err := openLevelStorage(dir, false)
+	if err != nil {
+		os.RemoveAll(dir)
+		b.Fatal(err)
+	}
+	err = writeSyntheticChain(db, cfg)
+	if err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		b.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+//the flags of utxoConvert for a whole chain with the given source
+func convertFlags(source string) cmdFlags {
+	startHeight := uint64(0)
+	endHeight := uint64(0)
+	flushEvery := uint64(0)
+	buildTxIndex := false
+	prefer := preferNone
+	return cmdFlags{
+		flagStartHeight: &startHeight,
+		flagEndHeight:   &endHeight,
+		flagFlushEvery:  &flushEvery,
+		flagTxIndex:     &buildTxIndex,
+		flagSource:      &source,
+		flagPrefer:      &prefer,
+	}
+}
+
+//the tools print a line for every block or pubkey, which would dominate the timings
+func silenceStdout(b *testing.B) func() {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	return func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}
+}
+
+//run fn and return its duration and the peak heap in use while it runs, sampled every millisecond
+func measure(fn func()) (time.Duration, uint64) {
+	runtime.GC()
+	var peak uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		var stats runtime.MemStats
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > peak {
+				peak = stats.HeapInuse
+			}
+			select {
+			case <-done:
+				close(sampled)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	start := time.Now()
+	fn()
+	elapsed := time.Since(start)
+	close(done)
+	<-sampled
+	return elapsed, peak
+}
+
+//report the utxos per second over the measured runs and the largest peak heap
+func reportMetrics(b *testing.B, utxos uint64, elapsed time.Duration, peak uint64) {
+	if elapsed > 0 {
+		b.ReportMetric(float64(utxos)*float64(b.N)/elapsed.Seconds(), "utxos/s")
+	}
+	b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+}
+
+//one sub-benchmark per source, so the numbers of a source stay comparable when the default changes.
+//"both" is the replay checked against the v0.3 utxos, which utxoConvert did before -source
+func BenchmarkUtxoConvert(b *testing.B) {
+	for _, source := range []string{sourceBoth, sourceBlocks, sourceStored} {
+		b.Run("source="+source, func(b *testing.B) {
+			benchmarkUtxoConvert(b, source)
+		})
+	}
+}
+
+func benchmarkUtxoConvert(b *testing.B, source string) {
+	cfg := benchSynthConfig()
+	defer silenceStdout(b)()
+	var total time.Duration
+	var peak uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db, cleanup := newSynthDB(b, cfg)
+		jr, err := startJournal(db, utxoConvert, schemaV3, schemaV5)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		elapsed, heap := measure(func() {
+			err = convertUtxos(context.Background(), db, "bench", convertFlags(source), jr)
+		})
+		b.StopTimer()
+		cleanup()
+		if err != nil {
+			b.Fatal(err)
+		}
+		total += elapsed
+		if heap > peak {
+			peak = heap
+		}
+	}
+	reportMetrics(b, cfg.utxos(), total, peak)
+}
+
+func BenchmarkDeleteAllUtxosFromNewDb(b *testing.B) {
+	cfg := benchSynthConfig()
+	defer silenceStdout(b)()
+	var total time.Duration
+	var peak uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db, cleanup := newSynthDB(b, cfg)
+		jr, err := startJournal(db, utxoConvert, schemaV3, schemaV5)
+		if err == nil {
+			err = convertUtxos(context.Background(), db, "bench", convertFlags(sourceBoth), jr)
+		}
+		if err != nil {
+			cleanup()
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		elapsed, heap := measure(func() {
+			_, err = DeleteAllUtxosFromNewDb(context.Background(), db)
+		})
+		b.StopTimer()
+		cleanup()
+		if err != nil {
+			b.Fatal(err)
+		}
+		total += elapsed
+		if heap > peak {
+			peak = heap
+		}
+	}
+	reportMetrics(b, cfg.utxos(), total, peak)
+}
+
+func BenchmarkWriteSyntheticChain(b *testing.B) {
+	cfg := benchSynthConfig()
+	var total time.Duration
+	var peak uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dir, err := ioutil.TempDir("", "utxo_generator_bench")
+		if err != nil {
+			b.Fatal(err)
+		}
+		db, err := openLevelStorage(dir, false)
+		if err != nil {
+			os.RemoveAll(dir)
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		elapsed, heap := measure(func() {
+			err = writeSyntheticChain(db, cfg)
+		})
+		b.StopTimer()
+		db.Close()
+		os.RemoveAll(dir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		total += elapsed
+		if heap > peak {
+			peak = heap
+		}
+	}
+	reportMetrics(b, cfg.utxos(), total, peak)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dappley/go-dappley/core/block"
+	"github.com/dappley/go-dappley/storage"
+)
+
+var (
+	ErrBlockHeightMismatch = errors.New("block height does not match the height index")
+	ErrBlockHashMismatch   = errors.New("block hash does not match the height index")
+	ErrHashChainBroken     = errors.New("block does not link to the block before it")
+)
+
+//walks the blocks by height and checks that each block links to the block before it, so that
+//transactions from a broken or forked height index are never applied
+type chainWalker struct {
+	db   storage.Storage
+	prev *block.Block
+}
+
+//the walker starts at startHeight, the block before it is loaded to check the link of the first block
+func newChainWalker(db storage.Storage, startHeight uint64) (*chainWalker, error) {
+	walker := &chainWalker{db: db}
+	if startHeight == 0 {
+		return walker, nil
+	}
+	prev, err := GetBlockByHeight(db, startHeight-1)
+	if err != nil {
+		return nil, err
+	}
+	err = checkBlockHeight(prev, startHeight-1)
+	if err != nil {
+		return nil, err
+	}
+	walker.prev = prev
+	return walker, nil
+}
+
+//get the block of the height, which must be the height after the previous call
+func (w *chainWalker) next(height uint64) (*block.Block, error) {
+	b, err := GetBlockByHeight(w.db, height)
+	if err != nil {
+		return nil, err
+	}
+	err = checkBlockHeight(b, height)
+	if err != nil {
+		return nil, err
+	}
+	if w.prev != nil {
+		if w.prev.GetHeight()+1 != height {
+			return nil, fmt.Errorf("%w: height %d does not follow height %d", ErrHashChainBroken, height, w.prev.GetHeight())
+		}
+		if !bytes.Equal(b.GetPrevHash(), w.prev.GetHash()) {
+			return nil, fmt.Errorf("%w: height %d, expected prev hash %s, actual prev hash %s",
+				ErrHashChainBroken, height, w.prev.GetHash().String(), b.GetPrevHash().String())
+		}
+	}
+	w.prev = b
+	return b, nil
+}
+
+func checkBlockHeight(b *block.Block, height uint64) error {
+	if b.GetHeight() != height {
+		return fmt.Errorf("%w: index height %d, block height %d, hash %s",
+			ErrBlockHeightMismatch, height, b.GetHeight(), b.GetHash().String())
+	}
+	return nil
+}
+
+//check the hash chain from startHeight to endHeight before anything is changed in the database
+//ctx cancellation stops the check with ErrInterrupted
+func validateHashChain(ctx context.Context, db storage.Storage, startHeight uint64, endHeight uint64) error {
+	walker, err := newChainWalker(db, startHeight)
+	if err != nil {
+		return err
+	}
+	for i := startHeight; i <= endHeight; i++ {
+		if err := checkInterrupted(ctx); err != nil {
+			return err
+		}
+		_, err = walker.next(i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
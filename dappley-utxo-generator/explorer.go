@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/dappley/go-dappley/core/block"
+	"github.com/dappley/go-dappley/core/transaction"
+	"github.com/dappley/go-dappley/storage"
+)
+
+var (
+	ErrNoBlockSelector = errors.New("no block given, use -height or -hash")
+	ErrInvalidTxid     = errors.New("invalid transaction id")
+)
+
+func blockCmdHandler(flags cmdFlags) {
+	dbname := *(flags[flagDatabase].(*string))
+	readOnly := *(flags[flagReadOnly].(*bool))
+	height := *(flags[flagHeight].(*uint64))
+	blockHash := *(flags[flagHash].(*string))
+
+	heightSet := isFlagSet(flags, flagHeight)
+	if !heightSet && blockHash == "" {
+		printError(ErrNoBlockSelector)
+		return
+	}
+	if heightSet && blockHash != "" {
+		printErrorf("%v, use either -height or -hash", ErrConflictingFlags)
+		return
+	}
+	db, err := LoadDBFile(dbname, readOnly)
+	if err != nil {
+		printError("fail to open db!", err)
+		return
+	}
+	defer db.Close()
+
+	var b *block.Block
+	if heightSet {
+		b, err = GetBlockByHeight(db, height)
+	} else {
+		b, err = GetBlockByHash(db, blockHash)
+	}
+	if err != nil {
+		printError(err)
+		return
+	}
+	printJSON(encodeBlock(b))
+}
+
+func tailCmdHandler(flags cmdFlags) {
+	dbname := *(flags[flagDatabase].(*string))
+	readOnly := *(flags[flagReadOnly].(*bool))
+
+	db, err := LoadDBFile(dbname, readOnly)
+	if err != nil {
+		printError("fail to open db!", err)
+		return
+	}
+	defer db.Close()
+	tailBlock, err := GetTailBlock(db)
+	if err != nil {
+		printError("fail to get tail block!", err)
+		return
+	}
+	printJSON(encodeBlock(tailBlock))
+}
+
+func txCmdHandler(flags cmdFlags) {
+	dbname := *(flags[flagDatabase].(*string))
+	readOnly := *(flags[flagReadOnly].(*bool))
+	txid := *(flags[flagTxid].(*string))
+
+	id, err := hex.DecodeString(txid)
+	if err != nil || len(id) == 0 {
+		printError(ErrInvalidTxid, txid)
+		return
+	}
+	db, err := LoadDBFile(dbname, readOnly)
+	if err != nil {
+		printError("fail to open db!", err)
+		return
+	}
+	defer db.Close()
+
+	//use the txid index in the db if it covers all blocks, otherwise the one next to the db, which is
+	//only written with -readonly=false. Read-only, or if that one cannot be opened, e.g. next to a
+	//write-protected snapshot, the blocks are scanned
+	var index storage.Storage = db
+	covered, err := isTxIndexComplete(db)
+	if err != nil {
+		printError("fail to check the txid index in the db!", err)
+		return
+	}
+	if !covered && readOnly {
+		index = nil
+	}
+	if !covered && !readOnly {
+		sideIndex, err := openLevelStorage(txIndexPath(dbname), false)
+		if err != nil {
+			printWarning("fail to open the txid index, scan the blocks instead.", err)
+			index = nil
+		} else {
+			defer sideIndex.Close()
+			err = updateTxIndex(context.Background(), db, sideIndex)
+			if err != nil {
+				printError("fail to update the txid index!", err)
+				return
+			}
+			index = sideIndex
+		}
+	}
+	var b *block.Block
+	var position uint64
+	var tx *transaction.Transaction
+	if index != nil {
+		b, position, tx, err = findTransaction(db, index, id)
+	} else {
+		b, position, tx, err = scanTransaction(db, id)
+	}
+	if err != nil {
+		printError(err)
+		return
+	}
+	printJSON(map[string]interface{}{
+		"BlockHash":   b.GetHash().String(),
+		"BlockHeight": b.GetHeight(),
+		"Position":    position,
+		"Transaction": encodeTransaction(tx),
+	})
+}
+
+func GetBlockByHash(db storage.Storage, blockHash string) (*block.Block, error) {
+	h, err := hex.DecodeString(blockHash)
+	if err != nil || len(h) == 0 {
+		return nil, fmt.Errorf("%w: invalid hash %s", ErrBlockDoesNotExist, blockHash)
+	}
+	rawBytes, err := db.Get(h)
+	if err != nil {
+		return nil, fmt.Errorf("%w: hash %s", ErrBlockDoesNotExist, blockHash)
+	}
+	b, err := parseBlock(rawBytes)
+	if err != nil || !bytes.Equal(b.GetHash(), h) {
+		return nil, fmt.Errorf("%w: hash %s", ErrBlockDoesNotExist, blockHash)
+	}
+	return b, nil
+}
+
+//with -output json the value becomes the data of the result object instead
+func printJSON(v interface{}) {
+	if jsonOutput {
+		result.Data = v
+		return
+	}
+	rawBytes, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		printError(err)
+		return
+	}
+	fmt.Println(string(rawBytes))
+}
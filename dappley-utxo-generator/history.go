@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/dappley/go-dappley/common"
+	"github.com/dappley/go-dappley/core/account"
+	"github.com/dappley/go-dappley/storage"
+)
+
+var (
+	ErrInvalidAddress = errors.New("invalid address or pubkey hash")
+	ErrInvalidFormat  = errors.New("invalid output format")
+)
+
+//output formats
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+//roles of an address in a transaction
+const (
+	roleInput  = "input"
+	roleOutput = "output"
+)
+
+//one row of the history: the total an address spent or received in a transaction
+type historyRow struct {
+	Height  uint64 `json:"height"`
+	Txid    string `json:"txid"`
+	Role    string `json:"role"`
+	Amount  string `json:"amount"`
+	Balance string `json:"balance"`
+}
+
+func historyCmdHandler(flags cmdFlags) {
+	dbname := *(flags[flagDatabase].(*string))
+	readOnly := *(flags[flagReadOnly].(*bool))
+	address := *(flags[flagAddress].(*string))
+	format := *(flags[flagFormat].(*string))
+
+	if format != formatTable && format != formatJSON && format != formatCSV {
+		printErrorf("%v %s, use %s, %s or %s", ErrInvalidFormat, format, formatTable, formatJSON, formatCSV)
+		return
+	}
+	pubKeyHash, err := parsePubKeyHash(address)
+	if err != nil {
+		printError(err)
+		return
+	}
+	db, err := LoadDBFile(dbname, readOnly)
+	if err != nil {
+		printError("fail to open db!", err)
+		return
+	}
+	defer db.Close()
+
+	rows, err := getAddressHistory(db, pubKeyHash)
+	if err != nil {
+		printError("fail to replay the blocks", err)
+		return
+	}
+	result.Counts["rows"] = len(rows)
+	if jsonOutput {
+		format = formatJSON
+	}
+	switch format {
+	case formatJSON:
+		printJSON(rows)
+	case formatCSV:
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"height", "txid", "role", "amount", "balance"})
+		for _, row := range rows {
+			w.Write([]string{strconv.FormatUint(row.Height, 10), row.Txid, row.Role, row.Amount, row.Balance})
+		}
+		w.Flush()
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HEIGHT\tTXID\tROLE\tAMOUNT\tBALANCE")
+		for _, row := range rows {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", row.Height, row.Txid, row.Role, row.Amount, row.Balance)
+		}
+		w.Flush()
+	}
+}
+
+//the address can be a dappley address or the hex string of a pubkey hash
+func parsePubKeyHash(address string) (account.PubKeyHash, error) {
+	if pubKeyHash, err := hex.DecodeString(address); err == nil && len(pubKeyHash) != 0 {
+		return account.PubKeyHash(pubKeyHash), nil
+	}
+	pubKeyHash, ok := account.GeneratePubKeyHashByAddress(account.NewAddress(address))
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, address)
+	}
+	return pubKeyHash, nil
+}
+
+//replay the blocks from the genesis block to the tail, and list every transaction that paid to or
+//spent from the pubkey hash. The outputs paid to it are tracked to find the inputs that spend them
+func getAddressHistory(db storage.Storage, pubKeyHash account.PubKeyHash) ([]historyRow, error) {
+	tailBlock, err := GetTailBlock(db)
+	if err != nil {
+		return nil, err
+	}
+	walker, err := newChainWalker(db, 0)
+	if err != nil {
+		return nil, err
+	}
+	//utxo key -> amount of the outputs paid to the pubkey hash that are not spent yet
+	owned := map[string]*common.Amount{}
+	balance := common.NewAmount(0)
+	var rows []historyRow
+	for i := uint64(0); i <= tailBlock.GetHeight(); i++ {
+		b, err := walker.next(i)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range b.GetTransactions() {
+			txid := hex.EncodeToString(tx.ID)
+			spent := common.NewAmount(0)
+			spends := false
+			for _, vin := range tx.Vin {
+				key := string(vin.Txid) + "_" + strconv.Itoa(vin.Vout)
+				if value, ok := owned[key]; ok {
+					spent = spent.Add(value)
+					spends = true
+					delete(owned, key)
+				}
+			}
+			if spends {
+				balance, err = balance.Sub(spent)
+				if err != nil {
+					return nil, err
+				}
+				rows = append(rows, historyRow{i, txid, roleInput, spent.String(), balance.String()})
+			}
+
+			received := common.NewAmount(0)
+			receives := false
+			for index, vout := range tx.Vout {
+				if !bytes.Equal(vout.PubKeyHash, pubKeyHash) {
+					continue
+				}
+				owned[string(tx.ID)+"_"+strconv.Itoa(index)] = vout.Value
+				received = received.Add(vout.Value)
+				receives = true
+			}
+			if receives {
+				balance = balance.Add(received)
+				rows = append(rows, historyRow{i, txid, roleOutput, received.String(), balance.String()})
+			}
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dappley/go-dappley/storage"
+)
+
+//set at build time with -ldflags "-X main.version=<version>"
+var version = "dev"
+
+const toolName = "utxo_generator"
+
+//reserved key prefix of the journal records, utxo_upgrade writes its records under the same prefix
+var journalPrefix = []byte("migrationJournal_")
+
+//utxo schema versions
+const (
+	schemaV3   = "v0.3.0"
+	schemaV5   = "v0.5.0"
+	schemaNone = "none"
+)
+
+//outcomes of a run
+const (
+	outcomeRunning     = "running"
+	outcomeSuccess     = "success"
+	outcomeFailed      = "failed"
+	outcomeInterrupted = "interrupted"
+)
+
+//one run of a tool that modifies the db
+type journalRecord struct {
+	Tool     string         `json:"tool"`
+	Version  string         `json:"version"`
+	Command  string         `json:"command"`
+	Args     []string       `json:"args"`
+	Started  time.Time      `json:"started"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Source   string         `json:"source_schema,omitempty"`
+	Target   string         `json:"target_schema,omitempty"`
+	Counts   map[string]int `json:"counts"`
+	Outcome  string         `json:"outcome"`
+	Error    string         `json:"error,omitempty"`
+}
+
+type journal struct {
+	key    []byte
+	record journalRecord
+}
+
+//the records are ordered by their start time
+func journalKey(started time.Time) []byte {
+	key := append([]byte{}, journalPrefix...)
+	nanos := make([]byte, 8)
+	binary.BigEndian.PutUint64(nanos, uint64(started.UnixNano()))
+	return append(key, nanos...)
+}
+
+//write a running record before the db is changed, so that an interrupted run leaves a trace. Commands
+//that do not change the utxo schema leave source and target empty
+func startJournal(db storage.Storage, command string, source string, target string) (*journal, error) {
+	started := time.Now().UTC()
+	jr := &journal{
+		key: journalKey(started),
+		record: journalRecord{
+			Tool:    toolName,
+			Version: version,
+			Command: command,
+			Args:    os.Args[1:],
+			Started: started,
+			Source:  source,
+			Target:  target,
+			Counts:  map[string]int{},
+			Outcome: outcomeRunning,
+		},
+	}
+	return jr, jr.save(db)
+}
+
+func (jr *journal) count(name string, n int) {
+	jr.record.Counts[name] += n
+}
+
+//record the end time and the outcome of the run, the counts are reported in the command result too
+func (jr *journal) finish(db storage.Storage, err error) {
+	for name, n := range jr.record.Counts {
+		result.Counts[name] = n
+	}
+	finished := time.Now().UTC()
+	jr.record.Finished = &finished
+	jr.record.Outcome = outcomeSuccess
+	if err != nil {
+		jr.record.Outcome = outcomeFailed
+		if errors.Is(err, ErrInterrupted) {
+			jr.record.Outcome = outcomeInterrupted
+		}
+		jr.record.Error = err.Error()
+	}
+	if saveErr := jr.save(db); saveErr != nil {
+		printWarning("fail to write the journal record!", saveErr)
+	}
+}
+
+func (jr *journal) save(db storage.Storage) error {
+	rawBytes, err := json.Marshal(jr.record)
+	if err != nil {
+		return err
+	}
+	return db.Put(jr.key, rawBytes)
+}
+
+func journalCmdHandler(flags cmdFlags) {
+	dbname := *(flags[flagDatabase].(*string))
+	format := *(flags[flagFormat].(*string))
+	readOnly := *(flags[flagReadOnly].(*bool))
+
+	if format != formatTable && format != formatJSON {
+		printErrorf("%v %s, use %s or %s", ErrInvalidFormat, format, formatTable, formatJSON)
+		return
+	}
+	if !isDbExist(dbname) {
+		printError(ErrFileNotExist)
+		return
+	}
+	db, err := openLevelStorage(dbname, readOnly)
+	if err != nil {
+		printError("fail to open db!", err)
+		return
+	}
+	defer db.Close()
+	records, err := getJournalRecords(db)
+	if err != nil {
+		printError("fail to read the journal!", err)
+		return
+	}
+	result.Counts["records"] = len(records)
+	if format == formatJSON || jsonOutput {
+		printJSON(records)
+		return
+	}
+	printJournalRecords(records)
+}
+
+func getJournalRecords(db *levelStorage) ([]journalRecord, error) {
+	var records []journalRecord
+	err := db.forEachPrefix(journalPrefix, func(key []byte, value []byte) error {
+		var record journalRecord
+		err := json.Unmarshal(value, &record)
+		if err != nil {
+			return fmt.Errorf("record %x: %w", key, err)
+		}
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+func printJournalRecords(records []journalRecord) {
+	if len(records) == 0 {
+		fmt.Println("The journal is empty")
+		return
+	}
+	for _, record := range records {
+		finished := "-"
+		if record.Finished != nil {
+			finished = record.Finished.Format(time.RFC3339)
+		}
+		fmt.Printf("%s  %s %s %s  %s\n", record.Started.Format(time.RFC3339), record.Tool, record.Version,
+			record.Command, record.Outcome)
+		fmt.Println("  args:", strings.Join(record.Args, " "))
+		fmt.Println("  finished:", finished)
+		if record.Source != "" || record.Target != "" {
+			fmt.Printf("  schema: %s -> %s\n", record.Source, record.Target)
+		}
+		var names []string
+		for name := range record.Counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %s: %d\n", name, record.Counts[name])
+		}
+		if record.Error != "" {
+			fmt.Println("  error:", record.Error)
+		}
+	}
+}
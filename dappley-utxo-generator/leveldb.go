@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/dappley/go-dappley/storage"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+//a storage.Storage on a leveldb opened by this tool, for the commands that also iterate over keys,
+//which storage.Storage does not support
+type levelStorage struct {
+	db    *leveldb.DB
+	batch *leveldb.Batch
+}
+
+//a read-only storage returns the leveldb read-only error on writes
+func openLevelStorage(path string, readOnly bool) (*levelStorage, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: readOnly})
+	if err != nil {
+		return nil, err
+	}
+	return &levelStorage{db: db}, nil
+}
+
+func (s *levelStorage) Get(key []byte) ([]byte, error) {
+	value, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, storage.ErrKeyInvalid
+	}
+	return value, err
+}
+
+func (s *levelStorage) Put(key []byte, val []byte) error {
+	if s.batch != nil {
+		s.batch.Put(key, val)
+		return nil
+	}
+	return s.db.Put(key, val, nil)
+}
+
+func (s *levelStorage) Del(key []byte) error {
+	if s.batch != nil {
+		s.batch.Delete(key)
+		return nil
+	}
+	return s.db.Delete(key, nil)
+}
+
+func (s *levelStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *levelStorage) EnableBatch() {
+	s.batch = new(leveldb.Batch)
+}
+
+func (s *levelStorage) DisableBatch() {
+	s.batch = nil
+}
+
+func (s *levelStorage) IsInBatchMode() bool {
+	return s.batch != nil
+}
+
+func (s *levelStorage) Flush() error {
+	if s.batch == nil {
+		return nil
+	}
+	err := s.db.Write(s.batch, nil)
+	s.batch.Reset()
+	return err
+}
+
+//call fn with a copy of every key that starts with prefix and its value
+func (s *levelStorage) forEachPrefix(prefix []byte, fn func(key []byte, value []byte) error) error {
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		err := fn(append([]byte{}, iter.Key()...), append([]byte{}, iter.Value()...))
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Error()
+}
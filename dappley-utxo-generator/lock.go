@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//suffix of the tool-level lock file created next to the database directory
+const toolLockSuffix = ".tool.lock"
+
+var (
+	ErrDBInUse       = errors.New("database is in use by another process")
+	ErrToolLockExist = errors.New("database is locked by another tool instance")
+)
+
+//content of the tool-level lock file
+type toolLockInfo struct {
+	Pid     int       `json:"pid"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+type toolLock struct {
+	path string
+}
+
+func toolLockPath(dbfilename string) string {
+	return filepath.Clean(dbfilename) + toolLockSuffix
+}
+
+//make sure neither a node nor another tool instance uses the database, then create the tool lock file.
+//force skips the LevelDB LOCK check and overrides an existing tool lock file
+func acquireDBLock(dbfilename string, force bool) (*toolLock, error) {
+	if !force {
+		err := checkLevelDBLock(dbfilename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lockPath := toolLockPath(dbfilename)
+	err := createToolLock(lockPath)
+	if os.IsExist(err) {
+		holder, readErr := readToolLock(lockPath)
+		if !force {
+			if readErr != nil {
+				return nil, fmt.Errorf("%w: unreadable lock file %s, use -force to override", ErrToolLockExist, lockPath)
+			}
+			state := "running"
+			if !processAlive(holder.Pid) {
+				state = "no longer running"
+			}
+			return nil, fmt.Errorf("%w: pid %d (%s) started at %s with \"%s\", use -force to override %s",
+				ErrToolLockExist, holder.Pid, state, holder.Started.Format(time.RFC3339), holder.Command, lockPath)
+		}
+		if readErr == nil {
+			fmt.Printf("Warning: overriding the lock of pid %d (\"%s\")\n", holder.Pid, holder.Command)
+		}
+		err = os.Remove(lockPath)
+		if err != nil {
+			return nil, err
+		}
+		err = createToolLock(lockPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &toolLock{path: lockPath}, nil
+}
+
+func createToolLock(lockPath string) error {
+	content, err := json.Marshal(toolLockInfo{
+		Pid:     os.Getpid(),
+		Command: strings.Join(os.Args, " "),
+		Started: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(lockPath)
+	}
+	return err
+}
+
+func readToolLock(lockPath string) (toolLockInfo, error) {
+	var info toolLockInfo
+	content, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(content, &info)
+	return info, err
+}
+
+//remove the tool lock file, only if it is still owned by this process
+func (l *toolLock) release() {
+	if l == nil {
+		return
+	}
+	holder, err := readToolLock(l.path)
+	if err != nil || holder.Pid != os.Getpid() {
+		return
+	}
+	os.Remove(l.path)
+}
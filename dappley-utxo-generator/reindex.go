@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	blockpb "github.com/dappley/go-dappley/core/block/pb"
+	"github.com/dappley/go-dappley/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var ErrNoGenesisChain = errors.New("no chain of blocks reaches a genesis block")
+
+//header fields of a block found by scanning the block hash keys
+type blockNode struct {
+	hash     string
+	prevHash string
+	height   uint64
+}
+
+//the result of a reindex scan
+type reindexPlan struct {
+	//main chain hashes by height, from the genesis block to the tail
+	chain []string
+	//blocks that are not on the main chain
+	orphans []*blockNode
+	puts    map[string][]byte
+	deletes [][]byte
+}
+
+func reindexCmdHandler(flags cmdFlags) {
+	dbname := *(flags[flagDatabase].(*string))
+	force := *(flags[flagForce].(*bool))
+	//a read-only database can only be reported on
+	dryRun := *(flags[flagDryRun].(*bool)) || *(flags[flagReadOnly].(*bool))
+
+	if !isDbExist(dbname) {
+		printError(ErrFileNotExist)
+		return
+	}
+	if !dryRun {
+		lock, err := acquireDBLock(dbname, force)
+		if err != nil {
+			printError(err)
+			return
+		}
+		defer lock.release()
+	}
+	db, err := leveldb.OpenFile(dbname, &opt.Options{ReadOnly: dryRun})
+	if err != nil {
+		printError("fail to open db!", err)
+		return
+	}
+	defer db.Close()
+
+	ctx, stop := interruptContext()
+	defer stop()
+	fmt.Println("Scan the blocks in the database...")
+	blocks, indexEntries, err := scanBlocks(ctx, db)
+	if errors.Is(err, ErrInterrupted) {
+		fmt.Println("Interrupted while scanning the blocks, nothing is written")
+		return
+	}
+	if err != nil {
+		printError("fail to scan db!", err)
+		return
+	}
+	tailHash, _ := db.Get(tipKey, nil)
+	plan, err := planReindex(blocks, indexEntries, tailHash)
+	if err != nil {
+		printError(err)
+		return
+	}
+
+	fmt.Println("The number of blocks is", len(blocks))
+	fmt.Printf("The longest chain has %d blocks, tail hash %s\n", len(plan.chain), plan.chain[len(plan.chain)-1])
+	for _, b := range plan.orphans {
+		fmt.Printf("Orphan or fork block: height %d, hash %s, prev hash %s\n", b.height, b.hash, b.prevHash)
+	}
+	fmt.Println("The number of index entries to write is", len(plan.puts))
+	fmt.Println("The number of stale index entries to delete is", len(plan.deletes))
+	if dryRun || (len(plan.puts) == 0 && len(plan.deletes) == 0) {
+		return
+	}
+	if checkInterrupted(ctx) != nil {
+		fmt.Println("Interrupted, nothing is written")
+		return
+	}
+
+	journalDB := &levelStorage{db: db}
+	jr, err := startJournal(journalDB, reindex, "", "")
+	if err != nil {
+		printError("fail to write the journal record!", err)
+		return
+	}
+	batch := new(leveldb.Batch)
+	for key, value := range plan.puts {
+		batch.Put([]byte(key), value)
+	}
+	for _, key := range plan.deletes {
+		batch.Delete(key)
+	}
+	err = db.Write(batch, nil)
+	jr.count("index_puts", len(plan.puts))
+	jr.count("index_deletes", len(plan.deletes))
+	jr.count("orphan_blocks", len(plan.orphans))
+	jr.finish(journalDB, err)
+	if err != nil {
+		printError("fail to write the height index!", err)
+		return
+	}
+	fmt.Println("Finish rebuilding the height index and the tail block hash...")
+}
+
+//find all blocks by their hash keys, and the keys that look like height index entries
+func scanBlocks(ctx context.Context, db *leveldb.DB) (map[string]*blockNode, map[string][]byte, error) {
+	blocks := map[string]*blockNode{}
+	//values of 8 byte keys, the candidates of height index entries
+	indexEntries := map[string][]byte{}
+	indexKeyLen := len(util.UintToHex(0))
+
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err := checkInterrupted(ctx); err != nil {
+			return nil, nil, err
+		}
+		key := iter.Key()
+		if len(key) == indexKeyLen {
+			indexEntries[string(key)] = append([]byte{}, iter.Value()...)
+			continue
+		}
+		blockPb := &blockpb.Block{}
+		if proto.Unmarshal(iter.Value(), blockPb) != nil {
+			continue
+		}
+		header := blockPb.GetHeader()
+		if len(key) == 0 || !bytes.Equal(header.GetHash(), key) {
+			continue
+		}
+		blocks[string(key)] = &blockNode{
+			hash:     hex.EncodeToString(header.GetHash()),
+			prevHash: hex.EncodeToString(header.GetPreviousHash()),
+			height:   header.GetHeight(),
+		}
+	}
+	return blocks, indexEntries, iter.Error()
+}
+
+//pick the longest chain that reaches a genesis block, and work out the index entries to fix
+func planReindex(blocks map[string]*blockNode, indexEntries map[string][]byte, tailHash []byte) (*reindexPlan, error) {
+	byHex := map[string]*blockNode{}
+	for _, b := range blocks {
+		byHex[b.hash] = b
+	}
+	//walk back from a block to the genesis block, nil if the chain is broken
+	chainOf := func(tip *blockNode) []string {
+		if tip.height >= uint64(len(blocks)) {
+			return nil
+		}
+		chain := make([]string, tip.height+1)
+		for b := tip; ; {
+			chain[b.height] = b.hash
+			if b.height == 0 {
+				return chain
+			}
+			prev, ok := byHex[b.prevHash]
+			if !ok || prev.height+1 != b.height {
+				return nil
+			}
+			b = prev
+		}
+	}
+
+	//only blocks that no other block points to can be tips
+	isPrev := map[string]bool{}
+	for _, b := range blocks {
+		isPrev[b.prevHash] = true
+	}
+	var tips []*blockNode
+	for _, b := range blocks {
+		if !isPrev[b.hash] {
+			tips = append(tips, b)
+		}
+	}
+	//prefer the higher tip, then the current tail, then the smaller hash
+	currentTail := hex.EncodeToString(tailHash)
+	sort.Slice(tips, func(i, j int) bool {
+		if tips[i].height != tips[j].height {
+			return tips[i].height > tips[j].height
+		}
+		if (tips[i].hash == currentTail) != (tips[j].hash == currentTail) {
+			return tips[i].hash == currentTail
+		}
+		return tips[i].hash < tips[j].hash
+	})
+	plan := &reindexPlan{puts: map[string][]byte{}}
+	for _, tip := range tips {
+		if plan.chain = chainOf(tip); plan.chain != nil {
+			break
+		}
+	}
+	if plan.chain == nil {
+		return nil, ErrNoGenesisChain
+	}
+
+	onChain := map[string]bool{}
+	for height, h := range plan.chain {
+		onChain[h] = true
+		hashBytes, _ := hex.DecodeString(h)
+		key := string(util.UintToHex(uint64(height)))
+		if !bytes.Equal(indexEntries[key], hashBytes) {
+			plan.puts[key] = hashBytes
+		}
+	}
+	for _, b := range blocks {
+		if !onChain[b.hash] {
+			plan.orphans = append(plan.orphans, b)
+		}
+	}
+	sort.Slice(plan.orphans, func(i, j int) bool {
+		if plan.orphans[i].height != plan.orphans[j].height {
+			return plan.orphans[i].height < plan.orphans[j].height
+		}
+		return plan.orphans[i].hash < plan.orphans[j].hash
+	})
+
+	//index entries above the new tail that point to a block of their height are stale
+	for key, value := range indexEntries {
+		b, ok := blocks[string(value)]
+		if !ok || b.height < uint64(len(plan.chain)) || key != string(util.UintToHex(b.height)) {
+			continue
+		}
+		plan.deletes = append(plan.deletes, []byte(key))
+	}
+
+	tail, _ := hex.DecodeString(plan.chain[len(plan.chain)-1])
+	if !bytes.Equal(tailHash, tail) {
+		plan.puts[string(tipKey)] = tail
+	}
+	return plan, nil
+}
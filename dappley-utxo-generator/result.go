@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//exit codes of the tool
+const (
+	exitOK           = 0
+	exitFailure      = 1
+	exitInvalidInput = 2
+	exitDBNotFound   = 3
+	exitCorruption   = 4
+	exitPartial      = 5
+)
+
+//values of the -output flag, which every command has
+const (
+	flagOutput = "output"
+	outputText = "text"
+	outputJSON = "json"
+)
+
+//status of a command result
+const (
+	statusOK      = "ok"
+	statusFailed  = "failed"
+	statusPartial = "partial"
+)
+
+var outputPars = flagPars{
+	flagOutput,
+	outputText,
+	valueTypeString,
+	"output format: text, or json to print one result object on stdout and the progress on stderr",
+}
+
+//the result of the command, printed as one json object with -output json
+type cmdResult struct {
+	Command  string         `json:"command"`
+	Status   string         `json:"status"`
+	ExitCode int            `json:"exit_code"`
+	Counts   map[string]int `json:"counts"`
+	Errors   []string       `json:"errors"`
+	Warnings []string       `json:"warnings"`
+	Data     interface{}    `json:"data,omitempty"`
+}
+
+var result = &cmdResult{Counts: map[string]int{}, Errors: []string{}, Warnings: []string{}}
+
+//set by -output json, the text output goes to stderr and stdout only gets the result
+var jsonOutput bool
+
+//the exit code of an error, the first error of a command decides the exit code
+func exitCodeOf(err error) int {
+	switch {
+	case errors.Is(err, ErrFileNotExist):
+		return exitDBNotFound
+	case errors.Is(err, ErrConflictingFlags), errors.Is(err, ErrInvalidFormat), errors.Is(err, ErrInvalidAddress),
+		errors.Is(err, ErrInvalidTxid), errors.Is(err, ErrNoBlockSelector), errors.Is(err, ErrRangeOverlap),
+		errors.Is(err, ErrRangeGap), errors.Is(err, ErrTxIndexGap), errors.Is(err, ErrInvalidRange), errors.Is(err, ErrFileExist),
+		errors.Is(err, ErrInvalidSource), errors.Is(err, ErrNoStoredUtxos):
+		return exitInvalidInput
+	case errors.Is(err, ErrUtxoMismatch), errors.Is(err, ErrSupplyMismatch), errors.Is(err, ErrTxIndexEntries),
+		errors.Is(err, ErrTxIndexMismatch), errors.Is(err, ErrConvertStateMismatch), errors.Is(err, ErrHashChainBroken),
+		errors.Is(err, ErrBlockHeightMismatch), errors.Is(err, ErrBlockHashMismatch):
+		return exitCorruption
+	}
+	return exitFailure
+}
+
+//set the exit code unless an earlier error set it
+func setExitCode(code int) {
+	if result.ExitCode == exitOK {
+		result.ExitCode = code
+	}
+}
+
+//print "Error:" and the values, the first error value decides the exit code
+func printError(a ...interface{}) {
+	fmt.Println(append([]interface{}{"Error:"}, a...)...)
+	addError(strings.TrimSpace(fmt.Sprintln(a...)), a)
+}
+
+func printErrorf(format string, a ...interface{}) {
+	fmt.Printf("Error: "+format+"\n", a...)
+	addError(fmt.Sprintf(format, a...), a)
+}
+
+func addError(message string, a []interface{}) {
+	result.Errors = append(result.Errors, message)
+	code := exitFailure
+	for _, v := range a {
+		if err, ok := v.(error); ok {
+			code = exitCodeOf(err)
+			break
+		}
+	}
+	setExitCode(code)
+}
+
+//warnings go to stderr, so they never mix with the json that some commands print
+func printWarning(a ...interface{}) {
+	fmt.Fprintln(os.Stderr, append([]interface{}{"Warning:"}, a...)...)
+	result.Warnings = append(result.Warnings, strings.TrimSpace(fmt.Sprintln(a...)))
+}
+
+func printWarningf(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Warning: "+format+"\n", a...)
+	result.Warnings = append(result.Warnings, fmt.Sprintf(format, a...))
+}
+
+//fill in the status, print the result object with -output json and return the exit code
+func finishResult(command string, stdout *os.File) int {
+	result.Command = command
+	switch result.ExitCode {
+	case exitOK:
+		result.Status = statusOK
+	case exitPartial:
+		result.Status = statusPartial
+	default:
+		result.Status = statusFailed
+	}
+	if jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(result)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: fail to print the result!", err)
+			return exitFailure
+		}
+	}
+	return result.ExitCode
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dappley/go-dappley/core/block"
+	"github.com/dappley/go-dappley/core/transactionbase"
+	"github.com/dappley/go-dappley/core/utxo"
+	"github.com/dappley/go-dappley/storage"
+)
+
+//sources of the converted utxo set
+const (
+	sourceStored = "stored"
+	sourceBlocks = "blocks"
+	sourceBoth   = "both"
+)
+
+//the set that is written when the stored utxos and the utxos of the blocks differ
+const (
+	preferNone   = "none"
+	preferStored = "stored"
+	preferBlocks = "blocks"
+)
+
+var (
+	ErrInvalidSource = errors.New("invalid utxo source")
+	ErrNoStoredUtxos = errors.New("the database has no stored v0.3 utxos")
+)
+
+//where utxoConvert takes the utxo set from, and which one wins when both are built and differ
+type migrationSource struct {
+	source string
+	prefer string
+}
+
+func migrationSourceOf(flags cmdFlags) (migrationSource, error) {
+	src := migrationSource{
+		source: *(flags[flagSource].(*string)),
+		prefer: *(flags[flagPrefer].(*string)),
+	}
+	switch src.source {
+	case sourceStored, sourceBlocks, sourceBoth:
+	default:
+		return src, fmt.Errorf("%w: -%s %s, use %s, %s or %s", ErrInvalidSource, flagSource, src.source, sourceStored, sourceBlocks, sourceBoth)
+	}
+	switch src.prefer {
+	case preferNone, preferStored, preferBlocks:
+	default:
+		return src, fmt.Errorf("%w: -%s %s, use %s, %s or %s", ErrInvalidSource, flagPrefer, src.prefer, preferNone, preferStored, preferBlocks)
+	}
+	if src.prefer != preferNone && src.source != sourceBoth {
+		return src, fmt.Errorf("%w: -%s only applies to -%s %s", ErrConflictingFlags, flagPrefer, flagSource, sourceBoth)
+	}
+	return src, nil
+}
+
+//whether the stored utxos may be written, they replace the whole set at once
+func (src migrationSource) writesStored() bool {
+	return src.source == sourceStored || src.prefer == preferStored
+}
+
+//the stored utxos can only replace the whole set, so the run must convert all blocks of a database
+//that has not been converted yet, and cannot write the txid index of the blocks
+func checkWholeSetMigration(flags cmdFlags, state *convertState) error {
+	for _, name := range []string{flagStartHeight, flagEndHeight, flagFlushEvery, flagTxIndex} {
+		if isFlagSet(flags, name) {
+			return fmt.Errorf("%w: -%s cannot be used when the stored utxos may be written", ErrConflictingFlags, name)
+		}
+	}
+	if state != nil {
+		return fmt.Errorf("%w: blocks up to height %d are converted, the stored utxos can only replace the whole set", ErrRangeOverlap, state.Height)
+	}
+	return nil
+}
+
+//convert the stored v0.3 utxos without replaying the blocks, the blocks are only read to find
+//the owners of the v0.3 utxo lists
+func migrateStoredUtxos(ctx context.Context, db storage.Storage, tailBlock *block.Block, jr *journal) error {
+	oldSet, err := GetOldUtxoSet(ctx, db, tailBlock.GetHeight())
+	if err != nil {
+		return fmt.Errorf("fail to get the old utxos: %w", err)
+	}
+	jr.count("old_utxos", len(oldSet.utxos))
+	if len(oldSet.listKeys) == 0 {
+		return ErrNoStoredUtxos
+	}
+	fmt.Println("Convert the stored utxos...")
+	staging := newStagingStorage(db)
+	err = stageStoredUtxos(staging, oldSet)
+	if err != nil {
+		return fmt.Errorf("fail to convert the stored utxos: %w", err)
+	}
+	err = promoteConvertedUtxos(db, staging, oldSet, tailBlock, true)
+	if err != nil {
+		return fmt.Errorf("fail to save the converted utxos, %w", err)
+	}
+	jr.count("stored_utxos", len(oldSet.utxos))
+	jr.count("deleted_old_lists", len(oldSet.listKeys))
+	fmt.Println("Delete all the old utxos in the database...")
+	return nil
+}
+
+//write the v0.3 utxos into the staging storage in the new structure, one utxo chain per pubkey
+func stageStoredUtxos(staging *stagingStorage, oldSet *oldUtxoSet) error {
+	utxoTxs := map[string]*utxo.UTXOTx{}
+	for key, old := range oldSet.utxos {
+		sep := strings.LastIndex(key, "_")
+		vout, err := strconv.Atoi(key[sep+1:])
+		if err != nil {
+			return fmt.Errorf("utxo %s: %w", utxoKeyString(key), err)
+		}
+		pubkey := old.pubKeyHash.String()
+		utxoTx, ok := utxoTxs[pubkey]
+		if !ok {
+			newUtxoTx := utxo.NewUTXOTx()
+			utxoTx = &newUtxoTx
+			utxoTxs[pubkey] = utxoTx
+		}
+		txout := transactionbase.TXOutput{Value: old.value, PubKeyHash: old.pubKeyHash, Contract: old.contract}
+		utxoTx.PutUtxo(utxo.NewUTXO(txout, []byte(key[:sep]), vout, old.utxoType))
+	}
+	var pubkeys []string
+	for pubkey := range utxoTxs {
+		pubkeys = append(pubkeys, pubkey)
+	}
+	sort.Strings(pubkeys)
+	utxoCache := utxo.NewUTXOCache(staging)
+	for _, pubkey := range pubkeys {
+		err := utxoCache.AddUtxos(utxoTxs[pubkey], pubkey)
+		if err != nil {
+			return fmt.Errorf("pubkey %s: %w", pubkey, err)
+		}
+	}
+	return nil
+}
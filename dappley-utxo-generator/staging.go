@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dappley/go-dappley/common"
+	"github.com/dappley/go-dappley/core/account"
+	"github.com/dappley/go-dappley/core/block"
+	"github.com/dappley/go-dappley/core/utxo"
+	"github.com/dappley/go-dappley/logic/lutxo"
+	"github.com/dappley/go-dappley/storage"
+	oldutxopb "github.com/dappley/go-dappley/tool/dappley-utxo-generator/oldpb"
+	"github.com/golang/protobuf/proto"
+)
+
+//the number of mismatches printed before the rest is summarized
+const maxPrintedMismatches = 20
+
+var ErrUtxoMismatch = errors.New("converted utxos do not match the old utxos")
+
+//keeps all writes in memory on top of the database, reads see the staged writes first.
+//Nothing reaches the database until the staged writes are promoted
+type stagingStorage struct {
+	base    storage.Storage
+	puts    map[string][]byte
+	deletes map[string]bool
+}
+
+func newStagingStorage(base storage.Storage) *stagingStorage {
+	return &stagingStorage{
+		base:    base,
+		puts:    map[string][]byte{},
+		deletes: map[string]bool{},
+	}
+}
+
+func (s *stagingStorage) Get(key []byte) ([]byte, error) {
+	if s.deletes[string(key)] {
+		return nil, storage.ErrKeyInvalid
+	}
+	if value, ok := s.puts[string(key)]; ok {
+		return value, nil
+	}
+	return s.base.Get(key)
+}
+
+func (s *stagingStorage) Put(key []byte, val []byte) error {
+	s.puts[string(key)] = append([]byte{}, val...)
+	delete(s.deletes, string(key))
+	return nil
+}
+
+func (s *stagingStorage) Del(key []byte) error {
+	delete(s.puts, string(key))
+	s.deletes[string(key)] = true
+	return nil
+}
+
+//the base database is closed by its owner
+func (s *stagingStorage) Close() error { return nil }
+
+func (s *stagingStorage) EnableBatch() {}
+
+func (s *stagingStorage) DisableBatch() {}
+
+func (s *stagingStorage) IsInBatchMode() bool { return false }
+
+func (s *stagingStorage) Flush() error { return nil }
+
+//write the staged changes into the base database, the caller decides whether it is done in a batch
+func (s *stagingStorage) promote() error {
+	for key, value := range s.puts {
+		err := s.base.Put([]byte(key), value)
+		if err != nil {
+			return err
+		}
+	}
+	for key := range s.deletes {
+		err := s.base.Del([]byte(key))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//drop the staged changes once they are in the database
+func (s *stagingStorage) reset() {
+	s.puts = map[string][]byte{}
+	s.deletes = map[string]bool{}
+}
+
+//check the utxos converted from the transactions of rangeTxids against the stored utxos as the source
+//asks, and write the converted utxos or the stored ones that src prefers, see promoteConvertedUtxos
+func saveConvertedUtxos(db storage.Storage, staging *stagingStorage, utxoIndex *lutxo.UTXOIndex, oldSet *oldUtxoSet,
+	rangeTxids map[string]bool, endBlock *block.Block, full bool, src migrationSource, jr *journal) error {
+	err := utxoIndex.Save()
+	if err != nil {
+		return err
+	}
+	//with -source both and no old utxos, convertUtxos only goes on with -prefer blocks
+	if src.source == sourceBoth && len(oldSet.utxos) > 0 {
+		fmt.Println("Check the converted utxos against the old utxos...")
+		mismatches := compareUtxoSets(oldSet, lutxo.NewUTXOIndex(utxo.NewUTXOCache(staging)), rangeTxids, full)
+		jr.count("mismatches", len(mismatches))
+		if len(mismatches) > 0 {
+			printUtxoMismatches(mismatches)
+			switch src.prefer {
+			case preferBlocks:
+				printWarningf("%d mismatches, the utxos of the blocks are written as -%s %s", len(mismatches), flagPrefer, src.prefer)
+			case preferStored:
+				printWarningf("%d mismatches, the stored utxos are written as -%s %s", len(mismatches), flagPrefer, src.prefer)
+				staging.reset()
+				err = stageStoredUtxos(staging, oldSet)
+				if err != nil {
+					return fmt.Errorf("fail to convert the stored utxos: %w", err)
+				}
+				jr.count("stored_utxos", len(oldSet.utxos))
+			default:
+				return fmt.Errorf("%w: %d mismatches, nothing after the last saved height is changed in the database", ErrUtxoMismatch, len(mismatches))
+			}
+		}
+	}
+	return promoteConvertedUtxos(db, staging, oldSet, endBlock, full)
+}
+
+//write the staged utxos with the conversion state of endBlock in one batch. The old utxos are
+//deleted in the same batch if the conversion reaches the tail
+func promoteConvertedUtxos(db storage.Storage, staging *stagingStorage, oldSet *oldUtxoSet, endBlock *block.Block, full bool) error {
+	db.EnableBatch()
+	defer db.DisableBatch()
+	err := staging.promote()
+	if err != nil {
+		return err
+	}
+	if full {
+		for _, key := range oldSet.listKeys {
+			err = db.Del(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = PutConvertState(db, endBlock)
+	if err != nil {
+		return err
+	}
+	err = db.Flush()
+	if err != nil {
+		return err
+	}
+	staging.reset()
+	return nil
+}
+
+//a utxo of the v0.3 structure
+type oldUtxo struct {
+	value      *common.Amount
+	pubKeyHash account.PubKeyHash
+	utxoType   utxo.UtxoType
+	contract   string
+}
+
+//the v0.3 utxo set stored in the database
+type oldUtxoSet struct {
+	utxos map[string]*oldUtxo
+	//keys of the v0.3 utxo lists, which are the raw pubkey hashes
+	listKeys [][]byte
+	//pubkey hashes of all vouts in the blocks, the owners of all possible utxos
+	pubKeyHashes []account.PubKeyHash
+}
+
+//read the v0.3 utxo lists of all pubkey hashes that appear in the blocks up to the tail
+//ctx cancellation stops the scan with ErrInterrupted
+func GetOldUtxoSet(ctx context.Context, db storage.Storage, tailHeight uint64) (*oldUtxoSet, error) {
+	set := &oldUtxoSet{utxos: map[string]*oldUtxo{}}
+	seen := map[string]bool{}
+	for i := uint64(0); i <= tailHeight; i++ {
+		if err := checkInterrupted(ctx); err != nil {
+			return nil, err
+		}
+		block, err := GetBlockByHeight(db, i)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range block.GetTransactions() {
+			for _, vout := range tx.Vout {
+				pkh := []byte(vout.PubKeyHash)
+				if seen[string(pkh)] {
+					continue
+				}
+				seen[string(pkh)] = true
+				set.pubKeyHashes = append(set.pubKeyHashes, vout.PubKeyHash)
+				rawBytes, err := db.Get(pkh)
+				if err != nil || rawBytes == nil {
+					continue
+				}
+				utxoList := &oldutxopb.UtxoList{}
+				if proto.Unmarshal(rawBytes, utxoList) != nil {
+					continue
+				}
+				set.listKeys = append(set.listKeys, pkh)
+				for _, utxoPb := range utxoList.GetUtxos() {
+					key := string(utxoPb.GetTxid()) + "_" + strconv.Itoa(int(utxoPb.GetTxIndex()))
+					set.utxos[key] = &oldUtxo{
+						value:      common.NewAmountFromBytes(utxoPb.GetAmount()),
+						pubKeyHash: account.PubKeyHash(utxoPb.GetPublicKeyHash()),
+						utxoType:   utxo.UtxoType(utxoPb.GetUtxoType()),
+						contract:   utxoPb.GetContract(),
+					}
+				}
+			}
+		}
+	}
+	return set, nil
+}
+
+//compare the converted utxos with the v0.3 utxos. Every v0.3 utxo created by a transaction of the
+//converted range must be converted unchanged. If the range reaches the tail, the converted set must
+//not contain any utxo that the v0.3 set does not have
+func compareUtxoSets(oldSet *oldUtxoSet, utxoIndex *lutxo.UTXOIndex, rangeTxids map[string]bool, full bool) []string {
+	//a part of the range only needs the converted utxos of the owners of its old utxos
+	pubKeyHashes := oldSet.pubKeyHashes
+	if !full {
+		pubKeyHashes = nil
+		seen := map[string]bool{}
+		for key, old := range oldSet.utxos {
+			if !rangeTxids[key[:strings.LastIndex(key, "_")]] || seen[string(old.pubKeyHash)] {
+				continue
+			}
+			seen[string(old.pubKeyHash)] = true
+			pubKeyHashes = append(pubKeyHashes, old.pubKeyHash)
+		}
+	}
+	newUtxos := map[string]*utxo.UTXO{}
+	for _, pkh := range pubKeyHashes {
+		utxoTx := utxoIndex.GetAllUTXOsByPubKeyHash(pkh)
+		if utxoTx == nil {
+			continue
+		}
+		for _, u := range utxoTx.Indices {
+			newUtxos[u.GetUTXOKey()] = u
+		}
+	}
+
+	var mismatches []string
+	for key, old := range oldSet.utxos {
+		txid := key[:strings.LastIndex(key, "_")]
+		if !rangeTxids[txid] {
+			continue
+		}
+		u, ok := newUtxos[key]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("missing %s pubkey=%s amount=%s", utxoKeyString(key), old.pubKeyHash.String(), old.value.String()))
+			continue
+		}
+		if u.Value.Cmp(old.value) != 0 || !bytes.Equal(u.PubKeyHash, old.pubKeyHash) || u.UtxoType != old.utxoType {
+			mismatches = append(mismatches, fmt.Sprintf("changed %s pubkey=%s amount=%s, converted pubkey=%s amount=%s",
+				utxoKeyString(key), old.pubKeyHash.String(), old.value.String(), u.PubKeyHash.String(), u.Value.String()))
+		}
+	}
+	if full {
+		for key, u := range newUtxos {
+			if _, ok := oldSet.utxos[key]; !ok {
+				mismatches = append(mismatches, fmt.Sprintf("extra %s pubkey=%s amount=%s", utxoKeyString(key), u.PubKeyHash.String(), u.Value.String()))
+			}
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
+
+func printUtxoMismatches(mismatches []string) {
+	for i, line := range mismatches {
+		if i == maxPrintedMismatches {
+			fmt.Printf("  ... and %d more\n", len(mismatches)-maxPrintedMismatches)
+			break
+		}
+		fmt.Println(" ", line)
+	}
+}
+
+//utxo key as "<hex txid>_<index>"
+func utxoKeyString(key string) string {
+	sep := strings.LastIndex(key, "_")
+	if sep < 0 {
+		return fmt.Sprintf("%x", key)
+	}
+	return fmt.Sprintf("%x%s", key[:sep], key[sep:])
+}
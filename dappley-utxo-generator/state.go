@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dappley/go-dappley/core/block"
+	"github.com/dappley/go-dappley/storage"
+)
+
+//key of the conversion state, which records the last block whose transactions are converted
+var convertStateKey = []byte("utxoConvertState")
+
+var (
+	ErrRangeOverlap         = errors.New("start height overlaps the converted blocks")
+	ErrRangeGap             = errors.New("start height leaves a gap after the converted blocks")
+	ErrConvertStateMismatch = errors.New("conversion state does not match the block at its height")
+)
+
+type convertState struct {
+	Height  uint64    `json:"height"`
+	Hash    string    `json:"hash"`
+	Updated time.Time `json:"updated"`
+}
+
+//get the conversion state, nil if the database has never been converted by this tool
+func GetConvertState(db storage.Storage) (*convertState, error) {
+	rawBytes, err := db.Get(convertStateKey)
+	if err == storage.ErrKeyInvalid {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &convertState{}
+	err = json.Unmarshal(rawBytes, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func PutConvertState(db storage.Storage, b *block.Block) error {
+	rawBytes, err := json.Marshal(convertState{
+		Height:  b.GetHeight(),
+		Hash:    hex.EncodeToString(b.GetHash()),
+		Updated: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return db.Put(convertStateKey, rawBytes)
+}
+
+//check that the converted block is still the block at that height in the index
+func checkConvertState(db storage.Storage, state *convertState) error {
+	b, err := GetBlockByHeight(db, state.Height)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(b.GetHash()) != state.Hash {
+		return fmt.Errorf("%w: height %d, converted hash %s, hash in the index %s",
+			ErrConvertStateMismatch, state.Height, state.Hash, hex.EncodeToString(b.GetHash()))
+	}
+	return nil
+}
+
+//work out the start height from the conversion state, an explicit start height must continue
+//right after the converted blocks
+func resolveStartHeight(db storage.Storage, state *convertState, startHeight uint64, startSet bool) (uint64, error) {
+	if state == nil {
+		if startSet && startHeight > 0 {
+			printWarning("the database has no conversion state, the start height cannot be checked")
+		}
+		return startHeight, nil
+	}
+	err := checkConvertState(db, state)
+	if err != nil {
+		return 0, err
+	}
+	next := state.Height + 1
+	if !startSet {
+		return next, nil
+	}
+	if startHeight < next {
+		return 0, fmt.Errorf("%w: blocks up to height %d are converted, start height is %d", ErrRangeOverlap, state.Height, startHeight)
+	}
+	if startHeight > next {
+		return 0, fmt.Errorf("%w: blocks up to height %d are converted, start height is %d", ErrRangeGap, state.Height, startHeight)
+	}
+	return startHeight, nil
+}
+
+func statusCmdHandler(flags cmdFlags) {
+	dbname := *(flags[flagDatabase].(*string))
+	readOnly := *(flags[flagReadOnly].(*bool))
+
+	db, err := LoadDBFile(dbname, readOnly)
+	if err != nil {
+		printError("fail to open db!", err)
+		return
+	}
+	defer db.Close()
+	tailBlock, err := GetTailBlock(db)
+	if err != nil {
+		printError("fail to get tail block!", err)
+		return
+	}
+	state, err := GetConvertState(db)
+	if err != nil {
+		printError("fail to get conversion state!", err)
+		return
+	}
+
+	data := map[string]interface{}{"TailHeight": tailBlock.GetHeight(), "TailHash": tailBlock.GetHash().String()}
+	result.Data = data
+	fmt.Println("Database:", dbname)
+	fmt.Printf("Tail height: %d, hash: %s\n", tailBlock.GetHeight(), tailBlock.GetHash().String())
+	if state == nil {
+		fmt.Println("Converted height: none, the database has not been converted by utxoConvert yet")
+		return
+	}
+	fmt.Printf("Converted height: %d, hash: %s, updated at %s\n", state.Height, state.Hash, state.Updated.Format(time.RFC3339))
+	data["ConvertedHeight"] = state.Height
+	data["ConvertedHash"] = state.Hash
+	err = checkConvertState(db, state)
+	if err != nil {
+		printError(err)
+		return
+	}
+	if state.Height >= tailBlock.GetHeight() {
+		fmt.Println("All blocks are converted")
+		return
+	}
+	fmt.Printf("Blocks to convert: %d, run utxoConvert without -start to continue from height %d\n",
+		tailBlock.GetHeight()-state.Height, state.Height+1)
+}
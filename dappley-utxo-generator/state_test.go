@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dappley/go-dappley/common/hash"
+	"github.com/dappley/go-dappley/core/block"
+	"github.com/dappley/go-dappley/storage"
+	"github.com/dappley/go-dappley/util"
+)
+
+//a chain of empty blocks linked by their hashes, with the height index and the tail hash
+func newTestChainDB(t *testing.T, blocks uint64) storage.Storage {
+	db := storage.NewRamStorage()
+	var prevHash hash.Hash
+	for height := uint64(0); height < blocks; height++ {
+		h := testBlockHash("block", height)
+		b := block.NewBlockWithRawInfo(h, prevHash, 0, int64(height), height, nil)
+		for key, value := range map[string][]byte{string(h): b.Serialize(), string(util.UintToHex(height)): h} {
+			if err := db.Put([]byte(key), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		prevHash = h
+	}
+	if err := db.Put(tipKey, prevHash); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func testBlockHash(name string, height uint64) hash.Hash {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s %d", name, height)))
+	return sum[:]
+}
+
+func TestConvertState(t *testing.T) {
+	db := newTestChainDB(t, 3)
+
+	state, err := GetConvertState(db)
+	if err != nil || state != nil {
+		t.Fatalf("state of a database that is not converted = %v, %v, want nil", state, err)
+	}
+	b, err := GetBlockByHeight(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = PutConvertState(db, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err = GetConvertState(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == nil || state.Height != 1 || state.Hash != hex.EncodeToString(b.GetHash()) {
+		t.Fatalf("state = %+v, want height 1 and hash %x", state, b.GetHash())
+	}
+	if err := checkConvertState(db, state); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveStartHeight(t *testing.T) {
+	db := newTestChainDB(t, 6)
+	b, err := GetBlockByHeight(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted := &convertState{Height: 2, Hash: hex.EncodeToString(b.GetHash())}
+	stale := &convertState{Height: 2, Hash: hex.EncodeToString(testBlockHash("stale", 2))}
+
+	tests := []struct {
+		name     string
+		state    *convertState
+		start    uint64
+		startSet bool
+		want     uint64
+		wantErr  error
+	}{
+		{name: "never converted", want: 0},
+		{name: "never converted, start given", start: 3, startSet: true, want: 3},
+		{name: "continue", state: converted, want: 3},
+		{name: "start right after the converted blocks", state: converted, start: 3, startSet: true, want: 3},
+		{name: "start overlaps the converted blocks", state: converted, start: 2, startSet: true, wantErr: ErrRangeOverlap},
+		{name: "start from the genesis block again", state: converted, start: 0, startSet: true, wantErr: ErrRangeOverlap},
+		{name: "start leaves a gap", state: converted, start: 4, startSet: true, wantErr: ErrRangeGap},
+		{name: "converted block replaced in the index", state: stale, wantErr: ErrConvertStateMismatch},
+		{name: "converted height beyond the tail", state: &convertState{Height: 9, Hash: converted.Hash}, wantErr: ErrBlockDoesNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveStartHeight(db, tt.state, tt.start, tt.startSet)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("resolveStartHeight() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveStartHeight() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/dappley/go-dappley/common"
+	"github.com/dappley/go-dappley/core/account"
+	"github.com/dappley/go-dappley/core/transaction"
+	utxopb "github.com/dappley/go-dappley/core/utxo/pb"
+	"github.com/dappley/go-dappley/storage"
+	"github.com/golang/protobuf/proto"
+)
+
+var ErrSupplyMismatch = errors.New("supply mismatch")
+
+//the amount and the owner of an output
+type ownedOutput struct {
+	value      *common.Amount
+	pubKeyHash account.PubKeyHash
+}
+
+//totals of a replay of all blocks
+type chainSupply struct {
+	//outputs of the transactions without inputs: coinbase, reward and gas transactions
+	minted *common.Amount
+	//inputs and outputs of the transactions that spend outputs, the difference is paid as fees
+	spent   *common.Amount
+	paid    *common.Amount
+	unspent map[string]*ownedOutput
+	//inputs that spend an output that does not exist or is spent already
+	unknownInputs int
+}
+
+//the utxos stored in the db, of the v0.3 and the v0.5 structure
+type storedSupply struct {
+	utxos    map[string]*ownedOutput
+	oldCount int
+	newCount int
+	//utxos stored in both structures, which happens while a conversion is not finished
+	bothCount int
+	//utxos stored in both structures with a different amount or owner
+	conflicts []string
+}
+
+type holder struct {
+	pubKeyHash string
+	amount     *common.Amount
+	count      int
+}
+
+func supplyCmdHandler(flags cmdFlags) {
+	dbname := *(flags[flagDatabase].(*string))
+	readOnly := *(flags[flagReadOnly].(*bool))
+	top := *(flags[flagTop].(*uint64))
+
+	if !isDbExist(dbname) {
+		printError("fail to open db!", ErrFileNotExist)
+		return
+	}
+	//the v0.5 utxos are read by iterating over the keys
+	db, err := openLevelStorage(dbname, readOnly)
+	if err != nil {
+		printError("fail to open db!", err)
+		return
+	}
+	defer db.Close()
+	tailBlock, err := GetTailBlock(db)
+	if err != nil {
+		printError("fail to get tail block!", err)
+		return
+	}
+
+	fmt.Println("Replay the blocks up to the tail height", tailBlock.GetHeight(), "...")
+	chain, err := replaySupply(db, tailBlock.GetHeight())
+	if err != nil {
+		printError("fail to replay the blocks", err)
+		return
+	}
+	fmt.Println("Read the stored utxos...")
+	oldSet, err := GetOldUtxoSet(context.Background(), db, tailBlock.GetHeight())
+	if err != nil {
+		printError("fail to get the old utxos", err)
+		return
+	}
+	newUtxos, err := getNewUtxos(db)
+	if err != nil {
+		printError("fail to read the new utxos", err)
+		return
+	}
+	stored := getStoredSupply(oldSet, newUtxos)
+
+	fees, err := chain.spent.Sub(chain.paid)
+	if err != nil {
+		printError("outputs of the spending transactions exceed their inputs", err)
+		return
+	}
+	expected, err := chain.minted.Sub(fees)
+	if err != nil {
+		printError("fees exceed the minted amount", err)
+		return
+	}
+	storedTotal := sumOutputs(stored.utxos)
+
+	fmt.Println("Minted by transactions without inputs:", chain.minted.String())
+	fmt.Println("Paid as fees:", fees.String())
+	fmt.Println("Expected supply:", expected.String())
+	fmt.Printf("Stored utxos: %d of the old structure, %d of the new structure, %d in both\n", stored.oldCount, stored.newCount, stored.bothCount)
+	fmt.Println("Stored supply:", storedTotal.String())
+	if chain.unknownInputs > 0 {
+		printWarning("the number of inputs that spend unknown outputs is", chain.unknownInputs)
+	}
+	if len(stored.conflicts) > 0 {
+		printWarningf("%d utxos differ between the old and the new structure", len(stored.conflicts))
+		printUtxoMismatches(stored.conflicts)
+	}
+
+	mismatches := compareSupply(chain.unspent, stored.utxos)
+	switch storedTotal.Cmp(expected) {
+	case 0:
+		fmt.Println("The stored supply matches the expected supply")
+	case 1:
+		diff, _ := storedTotal.Sub(expected)
+		printErrorf("%v: the stored supply exceeds the expected supply by %s", ErrSupplyMismatch, diff.String())
+	default:
+		diff, _ := expected.Sub(storedTotal)
+		printErrorf("%v: the stored supply is short of the expected supply by %s", ErrSupplyMismatch, diff.String())
+	}
+	if len(mismatches) > 0 {
+		printErrorf("%v: %d stored utxos differ from the unspent outputs of the replay", ErrSupplyMismatch, len(mismatches))
+		printUtxoMismatches(mismatches)
+	}
+	result.Counts["old_utxos"] = stored.oldCount
+	result.Counts["new_utxos"] = stored.newCount
+	result.Counts["utxos_in_both"] = stored.bothCount
+	result.Counts["mismatches"] = len(mismatches)
+
+	holders := topHolders(stored.utxos, int(top))
+	fmt.Println("Top holders:")
+	var topList []map[string]interface{}
+	for i, h := range holders {
+		fmt.Printf("  %d. %s amount=%s utxos=%d\n", i+1, h.pubKeyHash, h.amount.String(), h.count)
+		topList = append(topList, map[string]interface{}{"PubKeyHash": h.pubKeyHash, "Amount": h.amount.String(), "Utxos": h.count})
+	}
+	result.Data = map[string]interface{}{
+		"Minted":         chain.minted.String(),
+		"Fees":           fees.String(),
+		"ExpectedSupply": expected.String(),
+		"StoredSupply":   storedTotal.String(),
+		"TopHolders":     topList,
+	}
+}
+
+//mint transactions have no input that spends an output
+func isMintTx(tx *transaction.Transaction) bool {
+	for _, vin := range tx.Vin {
+		if len(vin.Txid) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//replay the blocks from the genesis block to the tail to get the minted amount, the fees and the
+//unspent outputs
+func replaySupply(db storage.Storage, tailHeight uint64) (*chainSupply, error) {
+	chain := &chainSupply{
+		minted:  common.NewAmount(0),
+		spent:   common.NewAmount(0),
+		paid:    common.NewAmount(0),
+		unspent: map[string]*ownedOutput{},
+	}
+	walker, err := newChainWalker(db, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i <= tailHeight; i++ {
+		b, err := walker.next(i)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range b.GetTransactions() {
+			mint := isMintTx(tx)
+			if !mint {
+				for _, vin := range tx.Vin {
+					key := string(vin.Txid) + "_" + strconv.Itoa(vin.Vout)
+					output, ok := chain.unspent[key]
+					if !ok {
+						chain.unknownInputs++
+						continue
+					}
+					chain.spent = chain.spent.Add(output.value)
+					delete(chain.unspent, key)
+				}
+			}
+			for index, vout := range tx.Vout {
+				chain.unspent[string(tx.ID)+"_"+strconv.Itoa(index)] = &ownedOutput{vout.Value, vout.PubKeyHash}
+				if mint {
+					chain.minted = chain.minted.Add(vout.Value)
+				} else {
+					chain.paid = chain.paid.Add(vout.Value)
+				}
+			}
+		}
+	}
+	return chain, nil
+}
+
+//read the v0.5 utxos from their keys, so the utxos of pubkeys that are in no vout of the blocks and
+//the utxos that no head links to are counted too
+func getNewUtxos(db *levelStorage) (map[string]*ownedOutput, error) {
+	utxos := map[string]*ownedOutput{}
+	err := db.forEachPrefix(nil, func(key []byte, value []byte) error {
+		utxoPb := &utxopb.Utxo{}
+		if proto.Unmarshal(value, utxoPb) != nil {
+			return nil
+		}
+		//a utxo is stored under txid + "_" + output index, see utxo.GetUTXOKey
+		if string(key) != string(utxoPb.GetTxid())+"_"+strconv.Itoa(int(utxoPb.GetTxIndex())) {
+			return nil
+		}
+		utxos[string(key)] = &ownedOutput{common.NewAmountFromBytes(utxoPb.GetAmount()), account.PubKeyHash(utxoPb.GetPublicKeyHash())}
+		return nil
+	})
+	return utxos, err
+}
+
+//merge the utxos of both structures by utxo key
+func getStoredSupply(oldSet *oldUtxoSet, newUtxos map[string]*ownedOutput) *storedSupply {
+	stored := &storedSupply{utxos: map[string]*ownedOutput{}}
+	for key, u := range oldSet.utxos {
+		stored.utxos[key] = &ownedOutput{u.value, u.pubKeyHash}
+		stored.oldCount++
+	}
+	for key, u := range newUtxos {
+		stored.newCount++
+		if old, ok := stored.utxos[key]; ok {
+			stored.bothCount++
+			if old.value.Cmp(u.value) != 0 || !bytes.Equal(old.pubKeyHash, u.pubKeyHash) {
+				stored.conflicts = append(stored.conflicts, fmt.Sprintf("conflict %s old pubkey=%s amount=%s, new pubkey=%s amount=%s",
+					utxoKeyString(key), old.pubKeyHash.String(), old.value.String(), u.pubKeyHash.String(), u.value.String()))
+			}
+			continue
+		}
+		stored.utxos[key] = u
+	}
+	sort.Strings(stored.conflicts)
+	return stored
+}
+
+//compare the stored utxos with the unspent outputs of the replay
+func compareSupply(unspent map[string]*ownedOutput, stored map[string]*ownedOutput) []string {
+	var mismatches []string
+	for key, output := range unspent {
+		u, ok := stored[key]
+		if !ok {
+			mismatches = append(mismatches, fmt.Sprintf("missing %s pubkey=%s amount=%s", utxoKeyString(key), output.pubKeyHash.String(), output.value.String()))
+			continue
+		}
+		if u.value.Cmp(output.value) != 0 || !bytes.Equal(u.pubKeyHash, output.pubKeyHash) {
+			mismatches = append(mismatches, fmt.Sprintf("changed %s pubkey=%s amount=%s, stored pubkey=%s amount=%s",
+				utxoKeyString(key), output.pubKeyHash.String(), output.value.String(), u.pubKeyHash.String(), u.value.String()))
+		}
+	}
+	for key, u := range stored {
+		if _, ok := unspent[key]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("extra %s pubkey=%s amount=%s", utxoKeyString(key), u.pubKeyHash.String(), u.value.String()))
+		}
+	}
+	sort.Strings(mismatches)
+	return mismatches
+}
+
+func sumOutputs(outputs map[string]*ownedOutput) *common.Amount {
+	total := common.NewAmount(0)
+	for _, output := range outputs {
+		total = total.Add(output.value)
+	}
+	return total
+}
+
+//the pubkey hashes with the largest amounts, ties broken by the pubkey hash
+func topHolders(outputs map[string]*ownedOutput, n int) []holder {
+	byPubkey := map[string]*holder{}
+	for _, output := range outputs {
+		pubkey := output.pubKeyHash.String()
+		h, ok := byPubkey[pubkey]
+		if !ok {
+			h = &holder{pubKeyHash: pubkey, amount: common.NewAmount(0)}
+			byPubkey[pubkey] = h
+		}
+		h.amount = h.amount.Add(output.value)
+		h.count++
+	}
+	var holders []holder
+	for _, h := range byPubkey {
+		holders = append(holders, *h)
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		if c := holders[i].amount.Cmp(holders[j].amount); c != 0 {
+			return c > 0
+		}
+		return holders[i].pubKeyHash < holders[j].pubKeyHash
+	})
+	if len(holders) > n {
+		holders = holders[:n]
+	}
+	return holders
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dappley/go-dappley/common"
+	"github.com/dappley/go-dappley/common/hash"
+	"github.com/dappley/go-dappley/core/account"
+	"github.com/dappley/go-dappley/core/block"
+	"github.com/dappley/go-dappley/core/transaction"
+	"github.com/dappley/go-dappley/core/transactionbase"
+	"github.com/dappley/go-dappley/storage"
+	oldutxopb "github.com/dappley/go-dappley/tool/dappley-utxo-generator/oldpb"
+	"github.com/dappley/go-dappley/util"
+	"github.com/golang/protobuf/proto"
+)
+
+var ErrFileExist = errors.New("file already exists")
+
+//shape of a synthetic chain. Every transaction has no inputs, so every output stays unspent and the
+//v0.3 utxo set holds one utxo per output
+type synthConfig struct {
+	blocks    uint64
+	txs       uint64
+	vouts     uint64
+	pubkeys   uint64
+	withOldV3 bool
+}
+
+func (cfg synthConfig) utxos() uint64 {
+	return cfg.blocks * cfg.txs * cfg.vouts
+}
+
+func synthCmdHandler(flags cmdFlags) {
+	dbname := *(flags[flagDatabase].(*string))
+	cfg := synthConfig{
+		blocks:    *(flags[flagBlocks].(*uint64)),
+		txs:       *(flags[flagTxs].(*uint64)),
+		vouts:     *(flags[flagVouts].(*uint64)),
+		pubkeys:   *(flags[flagPubkeys].(*uint64)),
+		withOldV3: *(flags[flagOldUtxos].(*bool)),
+	}
+
+	if isDbExist(dbname) {
+		printErrorf("%v: %s, synth only writes a new database", ErrFileExist, dbname)
+		return
+	}
+	if cfg.blocks == 0 || cfg.pubkeys == 0 {
+		printErrorf("%v: -blocks and -pubkeys should be larger than 0", ErrInvalidRange)
+		return
+	}
+	db, err := openLevelStorage(dbname, false)
+	if err != nil {
+		printError("fail to create the database!", err)
+		return
+	}
+	defer db.Close()
+
+	start := time.Now()
+	err = writeSyntheticChain(db, cfg)
+	if err != nil {
+		printError("fail to write the synthetic chain!", err)
+		return
+	}
+	result.Counts["blocks"] = int(cfg.blocks)
+	result.Counts["transactions"] = int(cfg.blocks * cfg.txs)
+	result.Counts["utxos"] = int(cfg.utxos())
+	fmt.Printf("Write %d blocks with %d utxos of %d pubkeys to %s in %v\n", cfg.blocks, cfg.utxos(), cfg.pubkeys,
+		dbname, time.Since(start).Round(time.Millisecond))
+}
+
+//write the blocks, the height index and the tail block hash of a synthetic chain, and with
+//withOldV3 the v0.3 utxo list of every pubkey. The blocks are written in batches of 1000 and the
+//utxo lists are kept in memory until the end
+func writeSyntheticChain(db storage.Storage, cfg synthConfig) error {
+	var lists map[string]*oldutxopb.UtxoList
+	if cfg.withOldV3 {
+		lists = map[string]*oldutxopb.UtxoList{}
+	}
+	pubKeyHashes := make([]account.PubKeyHash, cfg.pubkeys)
+	for i := range pubKeyHashes {
+		pubKeyHashes[i] = synthPubKeyHash(uint64(i))
+	}
+
+	db.EnableBatch()
+	defer db.DisableBatch()
+	var prevHash hash.Hash
+	output := uint64(0)
+	for height := uint64(0); height < cfg.blocks; height++ {
+		txs := make([]*transaction.Transaction, 0, cfg.txs)
+		for t := uint64(0); t < cfg.txs; t++ {
+			tx := &transaction.Transaction{ID: synthHash("tx", height, t), Tip: common.NewAmount(0)}
+			for v := uint64(0); v < cfg.vouts; v++ {
+				pkh := pubKeyHashes[output%cfg.pubkeys]
+				value := common.NewAmount(output%1000 + 1)
+				tx.Vout = append(tx.Vout, transactionbase.TXOutput{Value: value, PubKeyHash: pkh})
+				if lists != nil {
+					list, ok := lists[string(pkh)]
+					if !ok {
+						list = &oldutxopb.UtxoList{}
+						lists[string(pkh)] = list
+					}
+					list.Utxos = append(list.Utxos, &oldutxopb.Utxo{
+						Amount:        value.Bytes(),
+						PublicKeyHash: pkh,
+						Txid:          tx.ID,
+						TxIndex:       uint32(v),
+					})
+				}
+				output++
+			}
+			txs = append(txs, tx)
+		}
+		blockHash := hash.Hash(synthHash("block", height, 0))
+		b := block.NewBlockWithRawInfo(blockHash, prevHash, 0, int64(height), height, txs)
+		err := db.Put(blockHash, b.Serialize())
+		if err == nil {
+			err = db.Put(util.UintToHex(height), blockHash)
+		}
+		if err != nil {
+			return fmt.Errorf("block of height %d: %w", height, err)
+		}
+		prevHash = blockHash
+		if height%1000 == 999 {
+			err = db.Flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err := db.Put(tipKey, prevHash)
+	if err != nil {
+		return err
+	}
+	for pkh, list := range lists {
+		rawBytes, err := proto.Marshal(list)
+		if err != nil {
+			return err
+		}
+		err = db.Put([]byte(pkh), rawBytes)
+		if err != nil {
+			return err
+		}
+	}
+	return db.Flush()
+}
+
+//a pubkey hash with the version byte of a normal address
+func synthPubKeyHash(i uint64) account.PubKeyHash {
+	return append(account.PubKeyHash{0x5A}, synthHash("pubkey", i, 0)[:20]...)
+}
+
+func synthHash(kind string, a uint64, b uint64) []byte {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data, a)
+	binary.BigEndian.PutUint64(data[8:], b)
+	sum := sha256.Sum256(append([]byte(kind), data...))
+	return sum[:]
+}
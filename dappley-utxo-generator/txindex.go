@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/dappley/go-dappley/core/block"
+	"github.com/dappley/go-dappley/core/transaction"
+	"github.com/dappley/go-dappley/storage"
+)
+
+var (
+	ErrTxNotFound      = errors.New("transaction not found")
+	ErrNoTxIndex       = errors.New("db has no txid index")
+	ErrTxIndexVersion  = errors.New("txid index has an unsupported version")
+	ErrTxIndexMismatch = errors.New("txid index does not match the block at its height")
+	ErrTxIndexGap      = errors.New("txid index does not reach the block before the start height")
+	ErrTxIndexEntries  = errors.New("txid index entries are wrong")
+)
+
+//version of the txid index entries, a different version in the index state means the entries have
+//to be rebuilt
+const txIndexVersion = 1
+
+//key of the txid index state, which records the version and the last indexed block
+var txIndexStateKey = []byte("txIndexState")
+
+//prefix of the txid index entries, txid -> block height + position of the tx in the block
+var txIndexPrefix = []byte("txidx_")
+
+//the number of blocks indexed in one batch
+const txIndexBatchBlocks = 1000
+
+type txIndexState struct {
+	Version int    `json:"version"`
+	Height  uint64 `json:"height"`
+	Hash    string `json:"hash"`
+}
+
+func txindexCmdHandler(flags cmdFlags) {
+	dbname := *(flags[flagDatabase].(*string))
+	build := *(flags[flagBuild].(*bool))
+	force := *(flags[flagForce].(*bool))
+	readOnly := *(flags[flagReadOnly].(*bool))
+
+	if build && readOnly {
+		printErrorf("%v, use either -%s or -%s", ErrConflictingFlags, flagBuild, flagReadOnly)
+		return
+	}
+	if !isDbExist(dbname) {
+		printError(ErrFileNotExist)
+		return
+	}
+	if build {
+		lock, err := acquireDBLock(dbname, force)
+		if err != nil {
+			printError(err)
+			return
+		}
+		defer lock.release()
+	}
+	//without -build the database is only read
+	db, err := openLevelStorage(dbname, !build)
+	if err != nil {
+		printError("fail to open db!", err)
+		return
+	}
+	defer db.Close()
+
+	if build {
+		jr, err := startJournal(db, txIndexCmd, "", "")
+		if err != nil {
+			printError("fail to write the journal record!", err)
+			return
+		}
+		ctx, stop := interruptContext()
+		err = updateTxIndex(ctx, db, db)
+		stop()
+		jr.finish(db, err)
+		if errors.Is(err, ErrInterrupted) {
+			//the blocks indexed so far are saved
+			setExitCode(exitPartial)
+			printError(err)
+			fmt.Printf("Run \"txindex -file %s -build\" again to continue\n", dbname)
+			return
+		}
+		if err != nil {
+			printError("fail to build the txid index!", err)
+			return
+		}
+		fmt.Println("Finish building the txid index...")
+	}
+	fmt.Println("Verify the txid index...")
+	mismatches, count, err := verifyTxIndex(db, db)
+	if err != nil {
+		printError(err)
+		return
+	}
+	fmt.Println("The number of indexed transactions is", count)
+	result.Counts["transactions"] = count
+	result.Counts["mismatches"] = len(mismatches)
+	if len(mismatches) > 0 {
+		printErrorf("%v: %d entries, run txindex -build to rebuild the index", ErrTxIndexEntries, len(mismatches))
+		printUtxoMismatches(mismatches)
+		return
+	}
+	fmt.Println("The txid index is consistent with the blocks")
+}
+
+//the txid index used by the explorer commands is kept in its own leveldb next to the database, so
+//the database is never changed by them
+func txIndexPath(dbname string) string {
+	return filepath.Clean(dbname) + ".txindex"
+}
+
+func txIndexKey(id []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), id...)
+}
+
+func encodeTxIndexEntry(height uint64, position uint64) []byte {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value, height)
+	binary.BigEndian.PutUint64(value[8:], position)
+	return value
+}
+
+func decodeTxIndexEntry(value []byte) (uint64, uint64, bool) {
+	if len(value) != 16 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint64(value), binary.BigEndian.Uint64(value[8:]), true
+}
+
+//get the txid index state, nil if there is no txid index
+func GetTxIndexState(index storage.Storage) (*txIndexState, error) {
+	rawBytes, err := index.Get(txIndexStateKey)
+	if err == storage.ErrKeyInvalid {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &txIndexState{}
+	err = json.Unmarshal(rawBytes, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func PutTxIndexState(index storage.Storage, b *block.Block) error {
+	rawBytes, err := json.Marshal(txIndexState{
+		Version: txIndexVersion,
+		Height:  b.GetHeight(),
+		Hash:    hex.EncodeToString(b.GetHash()),
+	})
+	if err != nil {
+		return err
+	}
+	return index.Put(txIndexStateKey, rawBytes)
+}
+
+func putTxIndexEntries(index storage.Storage, b *block.Block) error {
+	for position, tx := range b.GetTransactions() {
+		err := index.Put(txIndexKey(tx.ID), encodeTxIndexEntry(b.GetHeight(), uint64(position)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//check the version of the index and that the last indexed block is still the block at that height
+func checkTxIndexState(db storage.Storage, state *txIndexState) error {
+	if state.Version != txIndexVersion {
+		return fmt.Errorf("%w: version %d, supported version %d", ErrTxIndexVersion, state.Version, txIndexVersion)
+	}
+	b, err := GetBlockByHeight(db, state.Height)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(b.GetHash()) != state.Hash {
+		return fmt.Errorf("%w: height %d, indexed hash %s, hash in the index %s",
+			ErrTxIndexMismatch, state.Height, state.Hash, hex.EncodeToString(b.GetHash()))
+	}
+	return nil
+}
+
+//the txid index in the db covers all blocks up to the tail
+func isTxIndexComplete(db storage.Storage) (bool, error) {
+	state, err := GetTxIndexState(db)
+	if err != nil || state == nil {
+		return false, err
+	}
+	if checkTxIndexState(db, state) != nil {
+		return false, nil
+	}
+	tailBlock, err := GetTailBlock(db)
+	if err != nil {
+		return false, err
+	}
+	return state.Height >= tailBlock.GetHeight(), nil
+}
+
+//the first height utxoConvert has to index when it starts at startHeight, the txid index must
+//reach the block before the start height
+func txIndexStartHeight(db storage.Storage, startHeight uint64) (uint64, error) {
+	state, err := GetTxIndexState(db)
+	if err != nil {
+		return 0, err
+	}
+	if state == nil {
+		if startHeight > 0 {
+			return 0, fmt.Errorf("%w: no blocks are indexed, start height is %d, run txindex -build first", ErrTxIndexGap, startHeight)
+		}
+		return 0, nil
+	}
+	err = checkTxIndexState(db, state)
+	if err != nil {
+		return 0, err
+	}
+	if state.Height+1 < startHeight {
+		return 0, fmt.Errorf("%w: blocks up to height %d are indexed, start height is %d, run txindex -build first", ErrTxIndexGap, state.Height, startHeight)
+	}
+	return state.Height + 1, nil
+}
+
+//index the blocks added since the last run up to the tail. The index is rebuilt if the last indexed
+//block is no longer on the chain or the index has another version. ctx cancellation saves the blocks
+//indexed so far
+func updateTxIndex(ctx context.Context, db storage.Storage, index *levelStorage) error {
+	tailBlock, err := GetTailBlock(db)
+	if err != nil {
+		return err
+	}
+	startHeight := uint64(0)
+	state, err := GetTxIndexState(index)
+	if err != nil {
+		return err
+	}
+	if state != nil && checkTxIndexState(db, state) == nil {
+		startHeight = state.Height + 1
+	}
+	if startHeight > tailBlock.GetHeight() {
+		return nil
+	}
+	if startHeight == 0 {
+		err = clearTxIndex(index)
+		if err != nil {
+			return err
+		}
+	}
+
+	//progress goes to stderr to keep the json output clean
+	fmt.Fprintln(os.Stderr, "Index the transactions of blocks", startHeight, "to", tailBlock.GetHeight(), "...")
+	walker, err := newChainWalker(db, startHeight)
+	if err != nil {
+		return err
+	}
+	index.EnableBatch()
+	defer index.DisableBatch()
+	var lastBlock *block.Block
+	for i := startHeight; i <= tailBlock.GetHeight(); i++ {
+		if checkInterrupted(ctx) != nil {
+			if lastBlock == nil {
+				return fmt.Errorf("%w before height %d", ErrInterrupted, i)
+			}
+			err = PutTxIndexState(index, lastBlock)
+			if err == nil {
+				err = index.Flush()
+			}
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%w, the transactions are indexed up to height %d", ErrInterrupted, i-1)
+		}
+		b, err := walker.next(i)
+		if err != nil {
+			return err
+		}
+		err = putTxIndexEntries(index, b)
+		if err != nil {
+			return err
+		}
+		lastBlock = b
+		if (i-startHeight+1)%txIndexBatchBlocks == 0 || i == tailBlock.GetHeight() {
+			err = PutTxIndexState(index, b)
+			if err != nil {
+				return err
+			}
+			err = index.Flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func clearTxIndex(index *levelStorage) error {
+	index.EnableBatch()
+	defer index.DisableBatch()
+	err := index.forEachPrefix(txIndexPrefix, func(key []byte, value []byte) error {
+		return index.Del(key)
+	})
+	if err != nil {
+		return err
+	}
+	err = index.Del(txIndexStateKey)
+	if err != nil {
+		return err
+	}
+	return index.Flush()
+}
+
+//look up the block and the position of a transaction in the txid index
+func findTransaction(db storage.Storage, index storage.Storage, id []byte) (*block.Block, uint64, *transaction.Transaction, error) {
+	value, err := index.Get(txIndexKey(id))
+	if err == storage.ErrKeyInvalid {
+		return nil, 0, nil, fmt.Errorf("%w: %x", ErrTxNotFound, id)
+	}
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	height, position, ok := decodeTxIndexEntry(value)
+	if !ok {
+		return nil, 0, nil, fmt.Errorf("%w: invalid index entry of %x", ErrTxNotFound, id)
+	}
+	b, err := GetBlockByHeight(db, height)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	txs := b.GetTransactions()
+	if position >= uint64(len(txs)) || !bytes.Equal(txs[position].ID, id) {
+		return nil, 0, nil, fmt.Errorf("%w: the index entry of %x is stale", ErrTxNotFound, id)
+	}
+	return b, position, txs[position], nil
+}
+
+//look up a transaction by walking all blocks up to the tail, for when no txid index can be used
+func scanTransaction(db storage.Storage, id []byte) (*block.Block, uint64, *transaction.Transaction, error) {
+	tailBlock, err := GetTailBlock(db)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	walker, err := newChainWalker(db, 0)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	for i := uint64(0); i <= tailBlock.GetHeight(); i++ {
+		b, err := walker.next(i)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		for position, tx := range b.GetTransactions() {
+			if bytes.Equal(tx.ID, id) {
+				return b, uint64(position), tx, nil
+			}
+		}
+	}
+	return nil, 0, nil, fmt.Errorf("%w: %x", ErrTxNotFound, id)
+}
+
+//check that every transaction up to the indexed height has its entry, and that there are no other
+//entries. Returns the wrong entries and the number of indexed transactions
+func verifyTxIndex(db storage.Storage, index *levelStorage) ([]string, int, error) {
+	state, err := GetTxIndexState(index)
+	if err != nil {
+		return nil, 0, err
+	}
+	if state == nil {
+		return nil, 0, ErrNoTxIndex
+	}
+	err = checkTxIndexState(db, state)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var mismatches []string
+	indexed := map[string]bool{}
+	walker, err := newChainWalker(db, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := uint64(0); i <= state.Height; i++ {
+		b, err := walker.next(i)
+		if err != nil {
+			return nil, 0, err
+		}
+		for position, tx := range b.GetTransactions() {
+			indexed[string(tx.ID)] = true
+			value, err := index.Get(txIndexKey(tx.ID))
+			if err == storage.ErrKeyInvalid {
+				mismatches = append(mismatches, fmt.Sprintf("missing %x height=%d position=%d", tx.ID, i, position))
+				continue
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			height, pos, ok := decodeTxIndexEntry(value)
+			if !ok || height != i || pos != uint64(position) {
+				mismatches = append(mismatches, fmt.Sprintf("wrong %x height=%d position=%d, entry %x", tx.ID, i, position, value))
+			}
+		}
+	}
+	err = index.forEachPrefix(txIndexPrefix, func(key []byte, value []byte) error {
+		id := key[len(txIndexPrefix):]
+		if !indexed[string(id)] {
+			mismatches = append(mismatches, fmt.Sprintf("extra %x entry %x", id, value))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Strings(mismatches)
+	return mismatches, len(indexed), nil
+}
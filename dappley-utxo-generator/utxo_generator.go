@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -11,11 +13,13 @@ import (
 	"github.com/dappley/go-dappley/common/hash"
 	"github.com/dappley/go-dappley/core/account"
 	"github.com/dappley/go-dappley/core/block"
+	blockpb "github.com/dappley/go-dappley/core/block/pb"
+	"github.com/dappley/go-dappley/core/transaction"
 	"github.com/dappley/go-dappley/core/utxo"
 	"github.com/dappley/go-dappley/logic/lutxo"
 	"github.com/dappley/go-dappley/storage"
 	"github.com/dappley/go-dappley/util"
-	"google.golang.org/grpc/status"
+	"github.com/golang/protobuf/proto"
 )
 
 var (
@@ -28,12 +32,24 @@ var (
 	ErrTailHashDoesNotExist = errors.New("tail hash does not exist in db")
 	ErrTargetHeightNotValid = errors.New("target height is not valid")
 	ErrFileNotExist         = errors.New("File does not exist")
+	ErrInvalidRange         = errors.New("invalid height range")
+	ErrConflictingFlags     = errors.New("conflicting flags")
 )
 
 //command names
 const (
 	utxoConvert = "utxoConvert"
 	utxoDelete  = "utxoDelete"
+	convStatus  = "status"
+	reindex     = "reindex"
+	blockCmd    = "block"
+	txCmd       = "tx"
+	tailCmd     = "tail"
+	history     = "history"
+	supply      = "supply"
+	txIndexCmd  = "txindex"
+	journalCmd  = "journal"
+	synthCmd    = "synth"
 	help        = "help"
 )
 
@@ -42,12 +58,41 @@ const (
 	flagDatabase    = "file"
 	flagStartHeight = "start"
 	flagEndHeight   = "end"
+	flagForce       = "force"
+	flagDryRun      = "dry-run"
+	flagFlushEvery  = "flush-every"
+	flagHeight      = "height"
+	flagHash        = "hash"
+	flagTxid        = "id"
+	flagAddress     = "address"
+	flagFormat      = "format"
+	flagTop         = "top"
+	flagTxIndex     = "txindex"
+	flagBuild       = "build"
+	flagReadOnly    = "readonly"
+	flagBlocks      = "blocks"
+	flagTxs         = "txs"
+	flagVouts       = "vouts"
+	flagPubkeys     = "pubkeys"
+	flagOldUtxos    = "v3"
+	flagSource      = "source"
+	flagPrefer      = "prefer"
 )
 
 //command list
 var cmdList = []string{
 	utxoConvert,
 	utxoDelete,
+	convStatus,
+	reindex,
+	blockCmd,
+	txCmd,
+	tailCmd,
+	history,
+	supply,
+	txIndexCmd,
+	journalCmd,
+	synthCmd,
 	help,
 }
 
@@ -57,6 +102,7 @@ type valueType int
 const (
 	valueTypeString = iota
 	valueTypeUint64
+	valueTypeBool
 )
 
 type flagPars struct {
@@ -68,8 +114,18 @@ type flagPars struct {
 
 //descryption of each function
 var descrip = map[string]string{
-	utxoConvert: "convert utxos from blocks from the start height to the end height including both endpoints",
+	utxoConvert: "convert utxos from blocks from the start height to the end height including both endpoints, the start height defaults to the block after the last converted one. -source chooses the stored utxos, the blocks or both as the source of the converted set",
 	utxoDelete:  "Delete all utxos in the database",
+	convStatus:  "show the converted height and the tail height of the database",
+	reindex:     "rebuild the height index and the tail block hash from the longest chain of blocks in the database",
+	blockCmd:    "print the header and the transactions of a block as json",
+	txCmd:       "print a transaction and the block it is in as json, the txid index is built next to the database on first use",
+	tailCmd:     "print the header and the transactions of the tail block as json",
+	history:     "list every transaction that paid to or spent from an address, with the running balance",
+	supply:      "compare the sum of all stored utxos with the amount minted in the blocks minus the fees, and list the top holders",
+	txIndexCmd:  "verify the txid index in the database, or build it up to the tail first",
+	journalCmd:  "list the journal of the tool runs that modified the database",
+	synthCmd:    "write a new database with a synthetic chain and its v0.3 utxo lists, for benchmarks and tests",
 }
 
 //configure input parameters/flags for each command
@@ -85,7 +141,7 @@ var cmdFlagsMap = map[string][]flagPars{
 			flagStartHeight,
 			uint64(0),
 			valueTypeUint64,
-			"start height. Eg. 0",
+			"start height, defaults to the block after the last converted one. Eg. 0",
 		},
 		flagPars{
 			flagEndHeight,
@@ -93,6 +149,36 @@ var cmdFlagsMap = map[string][]flagPars{
 			valueTypeUint64,
 			"end height. Eg. 0",
 		},
+		flagPars{
+			flagFlushEvery,
+			uint64(0),
+			valueTypeUint64,
+			"save the converted utxos and the converted height every N blocks so an interrupted run resumes there, 0 saves only at the end. Eg. 1000",
+		},
+		flagPars{
+			flagTxIndex,
+			false,
+			valueTypeBool,
+			"also write the txid index of the converted blocks",
+		},
+		flagPars{
+			flagSource,
+			sourceBoth,
+			valueTypeString,
+			"source of the converted utxos: stored (the v0.3 utxos), blocks (replay the blocks) or both (replay and check against the v0.3 utxos)",
+		},
+		flagPars{
+			flagPrefer,
+			preferNone,
+			valueTypeString,
+			"with -source both, the set to write when they differ: none (write nothing), stored or blocks",
+		},
+		flagPars{
+			flagForce,
+			false,
+			valueTypeBool,
+			"skip the in-use checks and override a stale tool lock",
+		},
 	},
 	utxoDelete: {
 		flagPars{
@@ -101,6 +187,242 @@ var cmdFlagsMap = map[string][]flagPars{
 			valueTypeString,
 			"database name. Eg. default.db",
 		},
+		flagPars{
+			flagForce,
+			false,
+			valueTypeBool,
+			"skip the in-use checks and override a stale tool lock",
+		},
+	},
+	convStatus: {
+		flagPars{
+			flagDatabase,
+			"default.db",
+			valueTypeString,
+			"database name. Eg. default.db",
+		},
+		flagPars{
+			flagReadOnly,
+			true,
+			valueTypeBool,
+			"open the database read-only, -readonly=false opens it read-write",
+		},
+	},
+	reindex: {
+		flagPars{
+			flagDatabase,
+			"default.db",
+			valueTypeString,
+			"database name. Eg. default.db",
+		},
+		flagPars{
+			flagReadOnly,
+			false,
+			valueTypeBool,
+			"report what would be written and open the database read-only, same as -dry-run",
+		},
+		flagPars{
+			flagForce,
+			false,
+			valueTypeBool,
+			"skip the in-use checks and override a stale tool lock",
+		},
+		flagPars{
+			flagDryRun,
+			false,
+			valueTypeBool,
+			"only report what would be written",
+		},
+	},
+	blockCmd: {
+		flagPars{
+			flagDatabase,
+			"default.db",
+			valueTypeString,
+			"database name. Eg. default.db",
+		},
+		flagPars{
+			flagReadOnly,
+			true,
+			valueTypeBool,
+			"open the database read-only, -readonly=false opens it read-write",
+		},
+		flagPars{
+			flagHeight,
+			uint64(0),
+			valueTypeUint64,
+			"block height. Eg. 10",
+		},
+		flagPars{
+			flagHash,
+			"",
+			valueTypeString,
+			"block hash in hex",
+		},
+	},
+	txCmd: {
+		flagPars{
+			flagDatabase,
+			"default.db",
+			valueTypeString,
+			"database name. Eg. default.db",
+		},
+		flagPars{
+			flagReadOnly,
+			true,
+			valueTypeBool,
+			"open the database read-only, -readonly=false opens it read-write",
+		},
+		flagPars{
+			flagTxid,
+			"",
+			valueTypeString,
+			"transaction id in hex",
+		},
+	},
+	tailCmd: {
+		flagPars{
+			flagDatabase,
+			"default.db",
+			valueTypeString,
+			"database name. Eg. default.db",
+		},
+		flagPars{
+			flagReadOnly,
+			true,
+			valueTypeBool,
+			"open the database read-only, -readonly=false opens it read-write",
+		},
+	},
+	history: {
+		flagPars{
+			flagDatabase,
+			"default.db",
+			valueTypeString,
+			"database name. Eg. default.db",
+		},
+		flagPars{
+			flagReadOnly,
+			true,
+			valueTypeBool,
+			"open the database read-only, -readonly=false opens it read-write",
+		},
+		flagPars{
+			flagAddress,
+			"",
+			valueTypeString,
+			"dappley address or pubkey hash in hex",
+		},
+		flagPars{
+			flagFormat,
+			formatTable,
+			valueTypeString,
+			"output format: table, json or csv",
+		},
+	},
+	supply: {
+		flagPars{
+			flagDatabase,
+			"default.db",
+			valueTypeString,
+			"database name. Eg. default.db",
+		},
+		flagPars{
+			flagReadOnly,
+			true,
+			valueTypeBool,
+			"open the database read-only, -readonly=false opens it read-write",
+		},
+		flagPars{
+			flagTop,
+			uint64(10),
+			valueTypeUint64,
+			"number of top holders to list. Eg. 10",
+		},
+	},
+	txIndexCmd: {
+		flagPars{
+			flagDatabase,
+			"default.db",
+			valueTypeString,
+			"database name. Eg. default.db",
+		},
+		flagPars{
+			flagReadOnly,
+			false,
+			valueTypeBool,
+			"verify only and open the database read-only, -build is refused",
+		},
+		flagPars{
+			flagBuild,
+			false,
+			valueTypeBool,
+			"index the blocks up to the tail before verifying, the index is rebuilt if it is stale",
+		},
+		flagPars{
+			flagForce,
+			false,
+			valueTypeBool,
+			"skip the in-use checks and override a stale tool lock",
+		},
+	},
+	journalCmd: {
+		flagPars{
+			flagDatabase,
+			"default.db",
+			valueTypeString,
+			"database name. Eg. default.db",
+		},
+		flagPars{
+			flagReadOnly,
+			true,
+			valueTypeBool,
+			"open the database read-only, -readonly=false opens it read-write",
+		},
+		flagPars{
+			flagFormat,
+			formatTable,
+			valueTypeString,
+			"output format: table or json",
+		},
+	},
+	synthCmd: {
+		flagPars{
+			flagDatabase,
+			"synth.db",
+			valueTypeString,
+			"name of the new database. Eg. synth.db",
+		},
+		flagPars{
+			flagBlocks,
+			uint64(1000),
+			valueTypeUint64,
+			"number of blocks",
+		},
+		flagPars{
+			flagTxs,
+			uint64(10),
+			valueTypeUint64,
+			"number of transactions in each block",
+		},
+		flagPars{
+			flagVouts,
+			uint64(10),
+			valueTypeUint64,
+			"number of outputs of each transaction",
+		},
+		flagPars{
+			flagPubkeys,
+			uint64(1000),
+			valueTypeUint64,
+			"number of pubkeys the outputs are spread over",
+		},
+		flagPars{
+			flagOldUtxos,
+			true,
+			valueTypeBool,
+			"write the v0.3 utxo list of every pubkey, -v3=false writes only the blocks",
+		},
 	},
 }
 
@@ -110,18 +432,36 @@ type commandHandler func(flags cmdFlags)
 var cmdHandlers = map[string]commandHandler{
 	utxoConvert: utxoConvertCmdHandler,
 	utxoDelete:  utxoDeleteCmdHandler,
+	convStatus:  statusCmdHandler,
+	reindex:     reindexCmdHandler,
+	blockCmd:    blockCmdHandler,
+	txCmd:       txCmdHandler,
+	tailCmd:     tailCmdHandler,
+	history:     historyCmdHandler,
+	supply:      supplyCmdHandler,
+	txIndexCmd:  txindexCmdHandler,
+	journalCmd:  journalCmdHandler,
+	synthCmd:    synthCmdHandler,
 	help:        helpCmdHandler,
 }
 
 //map key: flag name   map defaultValue: flag defaultValue
 type cmdFlags map[string]interface{}
 
+//prefix of the keys in cmdFlags that mark the flags set on the command line
+const flagSetPrefix = "set:"
+
+func isFlagSet(flags cmdFlags, name string) bool {
+	_, ok := flags[flagSetPrefix+name]
+	return ok
+}
+
 func main() {
 	args := os.Args[1:]
 
 	if len(args) < 1 {
 		printUsage()
-		return
+		os.Exit(exitInvalidInput)
 	}
 	cmdFlagSetList := map[string]*flag.FlagSet{}
 	//set up flagset for each command
@@ -131,14 +471,16 @@ func main() {
 	}
 	cmdFlagValues := map[string]cmdFlags{}
 	//set up flags for each command
-	for cmd, pars := range cmdFlagsMap {
+	for _, cmd := range cmdList {
 		cmdFlagValues[cmd] = cmdFlags{}
-		for _, par := range pars {
+		for _, par := range append(cmdFlagsMap[cmd], outputPars) {
 			switch par.valueType {
 			case valueTypeString:
 				cmdFlagValues[cmd][par.name] = cmdFlagSetList[cmd].String(par.name, par.defaultValue.(string), par.usage)
 			case valueTypeUint64:
 				cmdFlagValues[cmd][par.name] = cmdFlagSetList[cmd].Uint64(par.name, par.defaultValue.(uint64), par.usage)
+			case valueTypeBool:
+				cmdFlagValues[cmd][par.name] = cmdFlagSetList[cmd].Bool(par.name, par.defaultValue.(bool), par.usage)
 			}
 		}
 	}
@@ -148,15 +490,28 @@ func main() {
 	if cmd == nil {
 		fmt.Println("\nError:", cmdName, "is an invalid command")
 		printUsage()
-	} else {
-		err := cmd.Parse(args[1:])
-		if err != nil {
-			return
-		}
-		if cmd.Parsed() {
-			cmdHandlers[cmdName](cmdFlagValues[cmdName])
-		}
+		os.Exit(exitInvalidInput)
+	}
+	err := cmd.Parse(args[1:])
+	if err != nil {
+		os.Exit(exitInvalidInput)
 	}
+	cmd.Visit(func(f *flag.Flag) {
+		cmdFlagValues[cmdName][flagSetPrefix+f.Name] = true
+	})
+	output := *(cmdFlagValues[cmdName][flagOutput].(*string))
+	if output != outputText && output != outputJSON {
+		fmt.Printf("Error: %v %s, use %s or %s\n", ErrInvalidFormat, output, outputText, outputJSON)
+		os.Exit(exitInvalidInput)
+	}
+	//all text goes to stderr, so stdout only gets the result object
+	stdout := os.Stdout
+	if output == outputJSON {
+		jsonOutput = true
+		os.Stdout = os.Stderr
+	}
+	cmdHandlers[cmdName](cmdFlagValues[cmdName])
+	os.Exit(finishResult(cmdName, stdout))
 }
 
 //------------------------------------core functions-------------------------------------//
@@ -170,63 +525,183 @@ func printUsage() {
 
 func utxoConvertCmdHandler(flags cmdFlags) {
 	dbname := *(flags[flagDatabase].(*string))
-	startHeight := *(flags[flagStartHeight].(*uint64))
-	endHeight := *(flags[flagEndHeight].(*uint64))
+	force := *(flags[flagForce].(*bool))
 
-	db, err := LoadDBFile(dbname)
+	lock, err := acquireDBLock(dbname, force)
 	if err != nil {
-		fmt.Println("Error: File does not exist!")
+		printError(err)
+		return
+	}
+	defer lock.release()
+	db, err := LoadDBFile(dbname, false)
+	if err != nil {
+		printError(ErrFileNotExist)
 		return
 	}
 	defer db.Close()
+	jr, err := startJournal(db, utxoConvert, schemaV3, schemaV5)
+	if err != nil {
+		printError("fail to write the journal record!", err)
+		return
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	err = convertUtxos(ctx, db, dbname, flags, jr)
+	jr.finish(db, err)
+	if err != nil && jr.record.Counts["blocks"] > 0 {
+		//the blocks saved before the error stay converted
+		setExitCode(exitPartial)
+	}
+	if err != nil {
+		printError(err)
+	}
+	if errors.Is(err, ErrInterrupted) {
+		fmt.Printf("Run \"utxoConvert -file %s\" again to continue from the saved height\n", dbname)
+	}
+	if err != nil {
+		return
+	}
+	fmt.Println("Finish saving...")
+}
+
+//convert the utxos of the blocks in the height range of the flags and add the counts to the journal
+//record, ctx cancellation saves the blocks converted so far
+func convertUtxos(ctx context.Context, db storage.Storage, dbname string, flags cmdFlags, jr *journal) error {
+	startHeight := *(flags[flagStartHeight].(*uint64))
+	endHeight := *(flags[flagEndHeight].(*uint64))
+	flushEvery := *(flags[flagFlushEvery].(*uint64))
+	buildTxIndex := *(flags[flagTxIndex].(*bool))
+	src, err := migrationSourceOf(flags)
+	if err != nil {
+		return err
+	}
+
 	//check whether the start height and end height are valid
 	tailBlock, err := GetTailBlock(db)
 	if err != nil {
-		fmt.Println("Error: fail to get tail block!")
-		return
+		return fmt.Errorf("fail to get tail block: %w", err)
 	}
 	tailHeight := tailBlock.GetHeight()
+	//continue from the conversion state unless the start height is given
+	state, err := GetConvertState(db)
+	if err != nil {
+		return fmt.Errorf("fail to get conversion state: %w", err)
+	}
+	if src.writesStored() {
+		err = checkWholeSetMigration(flags, state)
+		if err != nil {
+			return err
+		}
+	}
+	if src.source == sourceStored {
+		return migrateStoredUtxos(ctx, db, tailBlock, jr)
+	}
+	startHeight, err = resolveStartHeight(db, state, startHeight, isFlagSet(flags, flagStartHeight))
+	if err != nil {
+		return err
+	}
+	if startHeight > tailHeight {
+		fmt.Println("All blocks up to the tail height", tailHeight, "are converted already")
+		return nil
+	}
 	//endHeight == 0 means the tailHeight
 	if endHeight == uint64(0) {
 		endHeight = tailHeight
 	}
 	if startHeight > endHeight {
-		fmt.Println("Error: start height should not be larger than the end height!")
-		return
+		return fmt.Errorf("%w: start height should not be larger than the end height", ErrInvalidRange)
 	}
 	if endHeight > tailHeight {
-		fmt.Println("Error: end height should not be larger than the tail height!")
-		return
+		return fmt.Errorf("%w: end height should not be larger than the tail height", ErrInvalidRange)
 	}
 	fmt.Printf("Current database is %s, start height = %d, end height = %d", dbname, startHeight, endHeight)
 
-	//delete all old utxos
-	keyExist, err := DeleteAllUtxosFromOldDb(db)
+	fmt.Println("\nCheck the hash chain of the blocks...")
+	err = validateHashChain(ctx, db, startHeight, endHeight)
 	if err != nil {
-		return
+		return fmt.Errorf("the height index is broken or forked, %w", err)
+	}
+
+	//the txid index is written in the same walk, it must continue right before the start height
+	txIndexFrom := uint64(0)
+	if buildTxIndex {
+		txIndexFrom, err = txIndexStartHeight(db, startHeight)
+		if err != nil {
+			return err
+		}
+	}
+
+	//the old utxos are only read here, they are deleted after the converted utxos are checked
+	oldSet, err := GetOldUtxoSet(ctx, db, tailHeight)
+	if err != nil {
+		return fmt.Errorf("fail to get the old utxos: %w", err)
 	}
-	if keyExist {
-		fmt.Println("\nDelete all the old utxos in the database...")
-	} else {
+	jr.count("old_utxos", len(oldSet.utxos))
+	if len(oldSet.listKeys) == 0 {
 		fmt.Println("\nThe old utxo structure doesn't exist in the database already...")
 	}
+	if len(oldSet.utxos) == 0 {
+		switch {
+		case src.source != sourceBoth:
+			fmt.Println("There are no old utxos to check the converted utxos against")
+		case src.prefer == preferBlocks:
+			printWarningf("there are no old utxos to check the converted utxos against, the utxos of the blocks are written as -%s %s", flagPrefer, src.prefer)
+		default:
+			return fmt.Errorf("%w to check the converted utxos against, use -%s %s to convert without the check", ErrNoStoredUtxos, flagSource, sourceBlocks)
+		}
+	}
 
 	largeSet := false
 	if tailHeight >= largeBlockNumBound {
 		largeSet = true
 	}
 
-	utxoCache := utxo.NewUTXOCache(db)
+	//all changes are staged in memory until the converted utxos are checked
+	staging := newStagingStorage(db)
+	utxoCache := utxo.NewUTXOCache(staging)
 	utxoIndex := lutxo.NewUTXOIndex(utxoCache)
+	walker, err := newChainWalker(db, startHeight)
+	if err != nil {
+		return fmt.Errorf("fail to get block: %w", err)
+	}
+	rangeTxids := map[string]bool{}
+	//first block that is not saved yet
+	chunkStart := startHeight
+	var lastBlock *block.Block
 	fmt.Println("Start converting transactions in blocks...")
 	for i := startHeight; i <= endHeight; i++ {
-		block, err := GetBlockByHeight(db, i)
+		if checkInterrupted(ctx) != nil {
+			//a part of the blocks cannot be checked against the whole stored set
+			if i == chunkStart || src.writesStored() {
+				return fmt.Errorf("%w before height %d, nothing after the last saved height is changed", ErrInterrupted, i)
+			}
+			err = saveConvertedUtxos(db, staging, utxoIndex, oldSet, rangeTxids, lastBlock, false, src, jr)
+			if err != nil {
+				return fmt.Errorf("fail to save the converted utxos up to height %d, %w", i-1, err)
+			}
+			jr.count("blocks", int(i-chunkStart))
+			jr.count("transactions", len(rangeTxids))
+			return fmt.Errorf("%w, the converted utxos are saved up to height %d", ErrInterrupted, i-1)
+		}
+		block, err := walker.next(i)
 		if err != nil {
-			fmt.Println("Error: fail to get block ", status.Convert(err).Message())
-			return
+			return fmt.Errorf("fail to get block: %w", err)
 		}
 		blkTxs := block.GetTransactions()
+		for _, tx := range blkTxs {
+			rangeTxids[string(tx.ID)] = true
+		}
 		utxoIndex.UpdateUtxos(blkTxs)
+		lastBlock = block
+		if buildTxIndex && i >= txIndexFrom {
+			err = putTxIndexEntries(staging, block)
+			if err == nil {
+				err = PutTxIndexState(staging, block)
+			}
+			if err != nil {
+				return fmt.Errorf("fail to index the transactions: %w", err)
+			}
+		}
 		if largeSet {
 			if i%100 == 0 {
 				//print the convert info every 100 blocks
@@ -237,57 +712,96 @@ func utxoConvertCmdHandler(flags cmdFlags) {
 			fmt.Println("Finish converting the block of height", i)
 			//PrintBlock(block)
 		}
+		//save the blocks converted so far and start over with an empty index
+		if flushEvery > 0 && i < endHeight && (i-startHeight+1)%flushEvery == 0 {
+			err = saveConvertedUtxos(db, staging, utxoIndex, oldSet, rangeTxids, block, false, src, jr)
+			if err != nil {
+				return fmt.Errorf("fail to save the converted utxos up to height %d, %w", i, err)
+			}
+			jr.count("blocks", int(i-chunkStart+1))
+			jr.count("transactions", len(rangeTxids))
+			fmt.Println("Save the converted utxos up to height", i)
+			utxoIndex = lutxo.NewUTXOIndex(utxo.NewUTXOCache(staging))
+			rangeTxids = map[string]bool{}
+			chunkStart = i + 1
+		}
 	}
-	//save the results in db
-	err = utxoIndex.Save()
+
+	endBlock, err := GetBlockByHeight(db, endHeight)
 	if err != nil {
-		fmt.Println("Error: fail to save utxoindex ", status.Convert(err).Message())
-		return
+		return fmt.Errorf("fail to get block: %w", err)
 	}
-	fmt.Println("Finish saving...")
+	full := endHeight == tailHeight
+	err = saveConvertedUtxos(db, staging, utxoIndex, oldSet, rangeTxids, endBlock, full, src, jr)
+	if err != nil {
+		return fmt.Errorf("fail to save the converted utxos up to height %d, %w", endHeight, err)
+	}
+	jr.count("blocks", int(endHeight-chunkStart+1))
+	jr.count("transactions", len(rangeTxids))
+	if full && len(oldSet.listKeys) > 0 {
+		jr.count("deleted_old_lists", len(oldSet.listKeys))
+		fmt.Println("Delete all the old utxos in the database...")
+	}
+	return nil
 }
 
 func utxoDeleteCmdHandler(flags cmdFlags) {
 	dbname := *(flags[flagDatabase].(*string))
-	db, err := LoadDBFile(dbname)
+	force := *(flags[flagForce].(*bool))
+
+	lock, err := acquireDBLock(dbname, force)
+	if err != nil {
+		printError(err)
+		return
+	}
+	defer lock.release()
+	db, err := LoadDBFile(dbname, false)
 	if err != nil {
-		fmt.Println("Error: File does not exist!")
+		printError(ErrFileNotExist)
 		return
 	}
 	defer db.Close()
-	isDeleted, err := DeleteAllUtxosFromNewDb(db)
+	jr, err := startJournal(db, utxoDelete, schemaV5, schemaNone)
 	if err != nil {
-		fmt.Println("Error: fail to delete all utxos from db!")
+		printError("fail to write the journal record!", err)
+		return
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	deleted, err := DeleteAllUtxosFromNewDb(ctx, db)
+	jr.count("pubkeys", deleted)
+	jr.finish(db, err)
+	if err != nil && deleted > 0 {
+		setExitCode(exitPartial)
+	}
+	if errors.Is(err, ErrInterrupted) {
+		printErrorf("%v after deleting the utxos of %d pubkeys, run \"utxoDelete -file %s\" again to delete the rest", err, deleted, dbname)
 		return
 	}
-	if isDeleted {
+	if err != nil {
+		printError("fail to delete all utxos from db!", err)
+		return
+	}
+	if deleted == 0 {
 		fmt.Println("All utxos have been already deleted!")
 	}
 }
 
 func helpCmdHandler(flag cmdFlags) {
-	for cmd, pars := range cmdFlagsMap {
+	for _, cmd := range cmdList {
+		if cmd == help {
+			continue
+		}
 		fmt.Println("\n-----------------------------------------------------------------")
 		fmt.Printf("Command: %s\n", cmd)
 		fmt.Printf("Description: %s\n", descrip[cmd])
-		fmt.Printf("Usage Example: ./utxo_generator %s", cmd)
-		for _, par := range pars {
-			fmt.Printf(" -%s", par.name)
-			if par.name == flagDatabase {
-				fmt.Printf(" default.db ")
-				continue
-			}
-			if par.name == flagStartHeight {
-				fmt.Printf(" 0 ")
-				continue
-			}
-			if par.name == flagEndHeight {
-				fmt.Printf(" 10 ")
-				continue
-			}
+		fmt.Printf("Usage: ./utxo_generator %s\n", cmd)
+		for _, par := range cmdFlagsMap[cmd] {
+			fmt.Printf("  -%s: %s\n", par.name, par.usage)
 		}
 	}
-	fmt.Println()
+	fmt.Printf("\nEvery command also takes -%s %s|%s. Exit codes: %d ok, %d failure, %d invalid input, %d database not found, %d corruption detected, %d partial success\n",
+		flagOutput, outputText, outputJSON, exitOK, exitFailure, exitInvalidInput, exitDBNotFound, exitCorruption, exitPartial)
 }
 
 //------------------------------------------help functions--------------------------//
@@ -299,11 +813,16 @@ func isDbExist(filename string) bool {
 	return true
 }
 
-func LoadDBFile(filename string) (storage.Storage, error) {
+//a read-only database is opened without the LevelDB lock for writing, and no MANIFEST or log file is
+//written, so backups and write-protected snapshots can be read
+func LoadDBFile(filename string, readOnly bool) (storage.Storage, error) {
 	isExist := isDbExist(filename)
 	if !isExist {
 		return nil, ErrFileNotExist
 	}
+	if readOnly {
+		return openLevelStorage(filename, true)
+	}
 	db := storage.OpenDatabase(filename)
 	return db, nil
 }
@@ -314,8 +833,18 @@ func GetBlockByHeight(db storage.Storage, height uint64) (*block.Block, error) {
 		return nil, ErrBlockDoesNotExist
 	}
 	rawBytes, err := db.Get(h)
-
-	return block.Deserialize(rawBytes), nil
+	if err != nil {
+		return nil, fmt.Errorf("%w: height %d, hash %s", ErrBlockDoesNotExist, height, hex.EncodeToString(h))
+	}
+	b, err := parseBlock(rawBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: height %d, hash %s cannot be parsed: %v", ErrBlockDoesNotExist, height, hex.EncodeToString(h), err)
+	}
+	if !bytes.Equal(b.GetHash(), h) {
+		return nil, fmt.Errorf("%w: height %d, index hash %s, block hash %s",
+			ErrBlockHashMismatch, height, hex.EncodeToString(h), b.GetHash().String())
+	}
+	return b, nil
 }
 
 func GetTailBlock(db storage.Storage) (*block.Block, error) {
@@ -324,59 +853,52 @@ func GetTailBlock(db storage.Storage) (*block.Block, error) {
 		return nil, ErrTailHashDoesNotExist
 	}
 	rawBytes, err := db.Get(hash)
-
-	return block.Deserialize(rawBytes), nil
-}
-
-func DeleteAllUtxosFromOldDb(db storage.Storage) (bool, error) {
-	tailBlock, err := GetTailBlock(db)
-	keyExist := false
 	if err != nil {
-		fmt.Println("Error: fail to get tail block!")
-		return false, err
+		return nil, fmt.Errorf("%w: tail hash %s", ErrBlockDoesNotExist, hex.EncodeToString(hash))
 	}
-	tailHeight := tailBlock.GetHeight()
-	for i := uint64(0); i <= tailHeight; i++ {
-		block, err := GetBlockByHeight(db, i)
-		if err != nil {
-			fmt.Println("Error: fail to get block ", status.Convert(err).Message())
-			return keyExist, err
-		}
-		blkTxs := block.GetTransactions()
-		for _, tx := range blkTxs {
-			for _, vout := range tx.Vout {
-				pkh := []byte(vout.PubKeyHash)
-				v, err := db.Get(pkh)
-				if v != nil && err != storage.ErrKeyInvalid {
-					err = db.Del(pkh)
-					if err != nil {
-						fmt.Println("Error: fail to delete pubkeyhash-utxotx pairs!")
-						return keyExist, err
-					}
-					keyExist = true
-				}
-			}
-		}
+	b, err := parseBlock(rawBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: tail hash %s cannot be parsed: %v", ErrBlockDoesNotExist, hex.EncodeToString(hash), err)
 	}
-	return keyExist, nil
+	return b, nil
 }
 
-func DeleteAllUtxosFromNewDb(db storage.Storage) (bool, error) {
+//block.Deserialize panics on bytes that are not a block, so they are unmarshaled here
+func parseBlock(rawBytes []byte) (*block.Block, error) {
+	blockPb := &blockpb.Block{}
+	if err := proto.Unmarshal(rawBytes, blockPb); err != nil {
+		return nil, err
+	}
+	b := &block.Block{}
+	b.FromProto(blockPb)
+	return b, nil
+}
+
+//delete the utxos of all pubkeys that appear in the blocks, and return the number of pubkeys whose
+//utxos are deleted
+//the blocks are walked as a hash chain, a broken chain stops before anything is deleted
+//ctx cancellation stops before the utxos of the next pubkey are removed
+func DeleteAllUtxosFromNewDb(ctx context.Context, db storage.Storage) (int, error) {
 	var pubKeySet map[string]int
 	pubKeySet = make(map[string]int)
 	keyId := 0
 	//store all pubkeys from the blocks into map
 	tailBlock, err := GetTailBlock(db)
 	if err != nil {
-		fmt.Println("Error: fail to get tail block!")
-		return false, err
+		return 0, fmt.Errorf("fail to get tail block: %w", err)
 	}
 	tailHeight := tailBlock.GetHeight()
+	walker, err := newChainWalker(db, 0)
+	if err != nil {
+		return 0, err
+	}
 	for i := uint64(0); i <= tailHeight; i++ {
-		block, err := GetBlockByHeight(db, i)
+		if err := checkInterrupted(ctx); err != nil {
+			return 0, err
+		}
+		block, err := walker.next(i)
 		if err != nil {
-			fmt.Println("Error: fail to get block ", status.Convert(err).Message())
-			return false, err
+			return 0, fmt.Errorf("fail to get block: %w", err)
 		}
 		blkTxs := block.GetTransactions()
 		for _, tx := range blkTxs {
@@ -392,33 +914,43 @@ func DeleteAllUtxosFromNewDb(db storage.Storage) (bool, error) {
 	}
 	//remove utxotx from db
 	utxoCache := utxo.NewUTXOCache(db)
-	isDeleted := true
+	deleted := 0
 	for pubkeyStr, _ := range pubKeySet {
+		if err := checkInterrupted(ctx); err != nil {
+			return deleted, err
+		}
 		pkBytes, err := hex.DecodeString(pubkeyStr)
+		if err != nil {
+			return deleted, fmt.Errorf("fail to decode pubkey %s: %w", pubkeyStr, err)
+		}
 		//first check ifthe pubkey still exist in the database
 		_, err = db.Get(util.Str2bytes(pubkeyStr))
 		if err != nil {
 			continue
 		}
-		isDeleted = false
-		if err != nil {
-			fmt.Println("Error: fail to decode pubkey string!")
-			return isDeleted, err
-		}
 		utxotx := utxoCache.GetUTXOTx(account.PubKeyHash(pkBytes))
 		err = utxoCache.RemoveUtxos(utxotx, pubkeyStr)
 		if err != nil {
-			fmt.Println("Error: fail to remove utxotx!")
-			return isDeleted, err
+			return deleted, fmt.Errorf("fail to remove the utxos of pubkey %s: %w", pubkeyStr, err)
 		}
+		deleted++
 		fmt.Println("Delete all utxos of pubkey", pubkeyStr)
 	}
-	return isDeleted, nil
+	return deleted, nil
 }
 
 func PrintBlock(b *block.Block) {
+	blockinfo, err := json.MarshalIndent(encodeBlock(b), "", "  ")
+	if err != nil {
+		printError(err)
+	}
+
+	fmt.Println(string(blockinfo))
+	fmt.Println("\n")
+}
 
-	encodedBlock := map[string]interface{}{
+func encodeBlock(b *block.Block) map[string]interface{} {
+	return map[string]interface{}{
 		"Header": map[string]interface{}{
 			"Hash":      b.GetHash().String(),
 			"Prevhash":  b.GetPrevHash().String(),
@@ -428,46 +960,41 @@ func PrintBlock(b *block.Block) {
 		},
 		"Transactions": tx_pretty_string(b),
 	}
-
-	blockinfo, err := json.MarshalIndent(encodedBlock, "", "  ")
-	if err != nil {
-		fmt.Println("Error:", err.Error())
-	}
-
-	fmt.Println(string(blockinfo))
-	fmt.Println("\n")
 }
 
 func tx_pretty_string(b *block.Block) []map[string]interface{} {
 	var encodedTransactions []map[string]interface{}
 
 	for _, transaction := range b.GetTransactions() {
+		encodedTransactions = append(encodedTransactions, encodeTransaction(transaction))
+	}
 
-		var encodedVin []map[string]interface{}
-		for _, vin := range transaction.Vin {
-			encodedVin = append(encodedVin, map[string]interface{}{
-				"Vout":      vin.Vout,
-				"Signature": hex.EncodeToString(vin.Signature),
-				"PubKey":    hex.EncodeToString(vin.PubKey),
-			})
-		}
+	return encodedTransactions
+}
 
-		var encodedVout []map[string]interface{}
-		for _, vout := range transaction.Vout {
-			encodedVout = append(encodedVout, map[string]interface{}{
-				"Value":      vout.Value,
-				"PubKeyHash": hex.EncodeToString(vout.PubKeyHash),
-				"Contract":   vout.Contract,
-			})
-		}
+func encodeTransaction(tx *transaction.Transaction) map[string]interface{} {
+	var encodedVin []map[string]interface{}
+	for _, vin := range tx.Vin {
+		encodedVin = append(encodedVin, map[string]interface{}{
+			"Txid":      hex.EncodeToString(vin.Txid),
+			"Vout":      vin.Vout,
+			"Signature": hex.EncodeToString(vin.Signature),
+			"PubKey":    hex.EncodeToString(vin.PubKey),
+		})
+	}
 
-		encodedTransaction := map[string]interface{}{
-			"ID":   hash.Hash(transaction.ID).String(),
-			"Vin":  encodedVin,
-			"Vout": encodedVout,
-		}
-		encodedTransactions = append(encodedTransactions, encodedTransaction)
+	var encodedVout []map[string]interface{}
+	for _, vout := range tx.Vout {
+		encodedVout = append(encodedVout, map[string]interface{}{
+			"Value":      vout.Value.String(),
+			"PubKeyHash": hex.EncodeToString(vout.PubKeyHash),
+			"Contract":   vout.Contract,
+		})
 	}
 
-	return encodedTransactions
+	return map[string]interface{}{
+		"ID":   hash.Hash(tx.ID).String(),
+		"Vin":  encodedVin,
+		"Vout": encodedVout,
+	}
 }
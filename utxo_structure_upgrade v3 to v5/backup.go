@@ -0,0 +1,488 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+const (
+	backupTimeLayout = "20060102T150405Z"
+	backupDBSuffix   = ".db"
+	archiveSuffix    = ".tar.gz"
+	manifestSuffix   = ".sha256"
+)
+
+var (
+	ErrBackupExist       = errors.New("backup already exists")
+	ErrBackupNotFound    = errors.New("backup not found")
+	ErrNoRetentionPolicy = errors.New("no retention policy, set -keep or -keep-days")
+)
+
+//where and how to back up a database before it is modified
+type backupConfig struct {
+	dir      string
+	compress bool
+	keep     uint64
+	keepDays uint64
+}
+
+//a backup is "<database>_<timestamp>.db" (a directory) or "<database>_<timestamp>.db.tar.gz",
+//with the sha256 manifest of every backed up file next to it
+type backupInfo struct {
+	Name       string
+	Path       string
+	Database   string
+	Created    time.Time
+	Compressed bool
+}
+
+func backupConfigFromFlags(flags cmdFlags) backupConfig {
+	return backupConfig{
+		dir:      *(flags[flagBackupDir].(*string)),
+		compress: *(flags[flagCompress].(*bool)),
+		keep:     *(flags[flagKeep].(*uint64)),
+		keepDays: *(flags[flagKeepDays].(*uint64)),
+	}
+}
+
+func backupsCmdHandler(flags cmdFlags) {
+	action := *(flags[flagAction].(*string))
+	dir := *(flags[flagBackupDir].(*string))
+	database := backupDatabaseName(*(flags[flagDatabase].(*string)))
+	name := *(flags[flagName].(*string))
+
+	switch action {
+	case "list":
+		list, err := listBackups(dir, database)
+		if err != nil {
+			logger.WithError(err).Error("Failed to list backups!")
+			return
+		}
+		result.Counts["backups"] = len(list)
+		fmt.Printf("%-45s %-22s %12s %6s %s\n", "NAME", "CREATED", "SIZE", "FILES", "COMPRESSED")
+		for _, b := range list {
+			size, _ := b.size()
+			files, _ := b.readManifest()
+			fmt.Printf("%-45s %-22s %12d %6d %t\n", b.Name, b.Created.Format(time.RFC3339), size, len(files), b.Compressed)
+		}
+	case "verify":
+		list, err := listBackups(dir, database)
+		if err != nil {
+			logger.WithError(err).Error("Failed to list backups!")
+			return
+		}
+		verified := 0
+		for _, b := range list {
+			if name != "" && b.Name != name {
+				continue
+			}
+			verified++
+			problems, err := b.verify()
+			if err != nil {
+				fmt.Printf("FAILED %s: %v\n", b.Name, err)
+				result.Counts["failed"]++
+				setExitCode(exitFailure)
+				continue
+			}
+			if len(problems) > 0 {
+				fmt.Printf("FAILED %s:\n  %s\n", b.Name, strings.Join(problems, "\n  "))
+				result.Counts["failed"]++
+				setExitCode(exitCorruption)
+				continue
+			}
+			fmt.Println("OK", b.Name)
+		}
+		result.Counts["verified"] = verified
+		if name != "" && verified == 0 {
+			logger.WithError(ErrBackupNotFound).Errorf("No backup named %s in %s", name, dir)
+		}
+	case "prune":
+		cfg := backupConfig{
+			dir:      dir,
+			keep:     *(flags[flagKeep].(*uint64)),
+			keepDays: *(flags[flagKeepDays].(*uint64)),
+		}
+		if cfg.keep == 0 && cfg.keepDays == 0 {
+			logger.WithError(ErrNoRetentionPolicy).Error("Nothing to prune!")
+			return
+		}
+		applyRetention(cfg, database)
+	}
+}
+
+//the database name a backup is grouped by, "nodes/node1.db" gives "node1"
+func backupDatabaseName(dbfilename string) string {
+	if dbfilename == "" {
+		return ""
+	}
+	return strings.TrimSuffix(filepath.Base(filepath.Clean(dbfilename)), backupDBSuffix)
+}
+
+//copy every file of the database into a new timestamped backup and write its sha256 manifest
+func createBackup(dbfilename string, cfg backupConfig) (backupInfo, error) {
+	created := time.Now().UTC()
+	b := backupInfo{
+		Database:   backupDatabaseName(dbfilename),
+		Created:    created,
+		Compressed: cfg.compress,
+	}
+	b.Name = b.Database + "_" + created.Format(backupTimeLayout) + backupDBSuffix
+	if cfg.compress {
+		b.Name += archiveSuffix
+	}
+	b.Path = filepath.Join(cfg.dir, b.Name)
+
+	err := os.MkdirAll(cfg.dir, 0755)
+	if err != nil {
+		return b, err
+	}
+	if _, err := os.Stat(b.Path); err == nil {
+		return b, fmt.Errorf("%w: %s", ErrBackupExist, b.Path)
+	}
+	files, err := listFiles(dbfilename)
+	if err != nil {
+		return b, err
+	}
+
+	var sums map[string]string
+	if cfg.compress {
+		sums, err = archiveFiles(dbfilename, files, b.Path)
+	} else {
+		sums, err = copyFiles(dbfilename, files, b.Path)
+	}
+	if err == nil {
+		err = writeManifest(b.Path+manifestSuffix, sums)
+	}
+	if err != nil {
+		os.RemoveAll(b.Path)
+		os.Remove(b.Path + manifestSuffix)
+		return b, err
+	}
+	return b, nil
+}
+
+//relative paths of all regular files under root, sorted
+func listFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func copyFiles(root string, files []string, target string) (map[string]string, error) {
+	sums := map[string]string{}
+	err := os.Mkdir(target, 0755)
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range files {
+		dst := filepath.Join(target, filepath.FromSlash(rel))
+		err = os.MkdirAll(filepath.Dir(dst), 0755)
+		if err != nil {
+			return nil, err
+		}
+		out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return nil, err
+		}
+		hasher := sha256.New()
+		err = copyFileTo(filepath.Join(root, filepath.FromSlash(rel)), io.MultiWriter(out, hasher))
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		sums[rel] = hex.EncodeToString(hasher.Sum(nil))
+	}
+	return sums, nil
+}
+
+func archiveFiles(root string, files []string, target string) (sums map[string]string, err error) {
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+
+	sums = map[string]string{}
+	for _, rel := range files {
+		src := filepath.Join(root, filepath.FromSlash(rel))
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return nil, err
+		}
+		header.Name = rel
+		err = tw.WriteHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		hasher := sha256.New()
+		err = copyFileTo(src, io.MultiWriter(tw, hasher))
+		if err != nil {
+			return nil, err
+		}
+		sums[rel] = hex.EncodeToString(hasher.Sum(nil))
+	}
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return sums, gw.Close()
+}
+
+func copyFileTo(src string, w io.Writer) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(w, in)
+	return err
+}
+
+//the manifest uses the output format of sha256sum, paths are relative to the backup
+func writeManifest(path string, sums map[string]string) error {
+	var files []string
+	for rel := range sums {
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+	var sb strings.Builder
+	for _, rel := range files {
+		fmt.Fprintf(&sb, "%s  %s\n", sums[rel], rel)
+	}
+	return ioutil.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+func (b backupInfo) readManifest() (map[string]string, error) {
+	f, err := os.Open(b.Path + manifestSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		sums[fields[1]] = fields[0]
+	}
+	return sums, scanner.Err()
+}
+
+//recompute the checksums of a backup and compare them with its manifest
+func (b backupInfo) verify() ([]string, error) {
+	expected, err := b.readManifest()
+	if err != nil {
+		return nil, err
+	}
+	actual := map[string]string{}
+	if b.Compressed {
+		err = hashArchive(b.Path, actual)
+	} else {
+		err = hashDir(b.Path, actual)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for rel, sum := range expected {
+		got, ok := actual[rel]
+		if !ok {
+			problems = append(problems, "missing "+rel)
+		} else if got != sum {
+			problems = append(problems, "checksum mismatch "+rel)
+		}
+	}
+	for rel := range actual {
+		if _, ok := expected[rel]; !ok {
+			problems = append(problems, "unexpected "+rel)
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
+
+func hashDir(root string, sums map[string]string) error {
+	files, err := listFiles(root)
+	if err != nil {
+		return err
+	}
+	for _, rel := range files {
+		hasher := sha256.New()
+		err = copyFileTo(filepath.Join(root, filepath.FromSlash(rel)), hasher)
+		if err != nil {
+			return err
+		}
+		sums[rel] = hex.EncodeToString(hasher.Sum(nil))
+	}
+	return nil
+}
+
+func hashArchive(path string, sums map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		_, err = io.Copy(hasher, tr)
+		if err != nil {
+			return err
+		}
+		sums[header.Name] = hex.EncodeToString(hasher.Sum(nil))
+	}
+}
+
+func (b backupInfo) size() (int64, error) {
+	var size int64
+	err := filepath.Walk(b.Path, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return err
+	})
+	return size, err
+}
+
+//parse a backup name, only entries with a manifest next to them are backups
+func parseBackupName(dir string, name string) (backupInfo, bool) {
+	b := backupInfo{Name: name, Path: filepath.Join(dir, name)}
+	base := name
+	if strings.HasSuffix(base, archiveSuffix) {
+		b.Compressed = true
+		base = strings.TrimSuffix(base, archiveSuffix)
+	}
+	if !strings.HasSuffix(base, backupDBSuffix) {
+		return b, false
+	}
+	base = strings.TrimSuffix(base, backupDBSuffix)
+	sep := strings.LastIndex(base, "_")
+	if sep < 0 {
+		return b, false
+	}
+	created, err := time.Parse(backupTimeLayout, base[sep+1:])
+	if err != nil {
+		return b, false
+	}
+	if _, err := os.Stat(b.Path + manifestSuffix); err != nil {
+		return b, false
+	}
+	b.Database = base[:sep]
+	b.Created = created
+	return b, true
+}
+
+//all backups in dir, of the given database only if it is not empty, sorted by database and then by time
+func listBackups(dir string, database string) ([]backupInfo, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var list []backupInfo
+	for _, entry := range entries {
+		b, ok := parseBackupName(dir, entry.Name())
+		if !ok || (database != "" && b.Database != database) {
+			continue
+		}
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Database != list[j].Database {
+			return list[i].Database < list[j].Database
+		}
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list, nil
+}
+
+//backups to remove: all but the newest keep backups of each database, and the ones older than keepDays
+func selectExpiredBackups(list []backupInfo, keep uint64, keepDays uint64, now time.Time) []backupInfo {
+	var expired []backupInfo
+	kept := map[string]uint64{}
+	deadline := now.Add(-time.Duration(keepDays) * 24 * time.Hour)
+	for i := len(list) - 1; i >= 0; i-- {
+		b := list[i]
+		if (keep > 0 && kept[b.Database] >= keep) || (keepDays > 0 && b.Created.Before(deadline)) {
+			expired = append(expired, b)
+			continue
+		}
+		kept[b.Database]++
+	}
+	return expired
+}
+
+//prune the backups of the database (all databases if empty) following the retention policy of cfg
+func applyRetention(cfg backupConfig, database string) {
+	if cfg.keep == 0 && cfg.keepDays == 0 {
+		return
+	}
+	list, err := listBackups(cfg.dir, database)
+	if err != nil {
+		logger.WithError(err).Error("Failed to list backups!")
+		return
+	}
+	for _, b := range selectExpiredBackups(list, cfg.keep, cfg.keepDays, time.Now().UTC()) {
+		err = os.RemoveAll(b.Path)
+		if err == nil {
+			err = os.Remove(b.Path + manifestSuffix)
+		}
+		if err != nil {
+			logger.WithError(err).Errorf("Failed to remove backup %s", b.Name)
+			continue
+		}
+		fmt.Println("Removed backup", b.Name)
+	}
+}
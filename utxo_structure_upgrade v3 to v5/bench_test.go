@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/dappley/go-dappley/common"
+	"github.com/dappley/go-dappley/storage"
+	oldutxopb "github.com/dappley/go-dappley/tool/utxo_structure_upgrade/oldpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+//size of the synthetic v0.3 utxo set, eg. go test -run none -bench . -synth.lists 100000 -synth.utxos 10
+//for 1M utxos
+var (
+	synthLists = flag.Int("synth.lists", 1000, "number of v0.3 utxo lists, one for each pubkey")
+	synthUtxos = flag.Int("synth.utxos", 10, "number of utxos in each list")
+)
+
+//write a new database with the synthetic v0.3 utxo lists in dir/default.db, and return its path
+func writeSyntheticUtxoLists(b *testing.B, dir string, lists int, utxos int) string {
+	dbfilename := filepath.Join(dir, "default.db")
+	db, err := leveldb.OpenFile(dbfilename, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	batch := new(leveldb.Batch)
+	for i := 0; i < lists; i++ {
+		pubKeyHash := append([]byte{0x5A}, synthHash("pubkey", i, 0)[:20]...)
+		list := &oldutxopb.UtxoList{}
+		for j := 0; j < utxos; j++ {
+			list.Utxos = append(list.Utxos, &oldutxopb.Utxo{
+				Amount:        common.NewAmount(uint64(j + 1)).Bytes(),
+				PublicKeyHash: pubKeyHash,
+				Txid:          synthHash("tx", i, j),
+				TxIndex:       uint32(j % 4),
+			})
+		}
+		rawBytes, err := proto.Marshal(list)
+		if err != nil {
+			b.Fatal(err)
+		}
+		batch.Put(pubKeyHash, rawBytes)
+		if batch.Len() >= 1000 {
+			err = db.Write(batch, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			batch.Reset()
+		}
+	}
+	err = db.Write(batch, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return dbfilename
+}
+
+func synthHash(kind string, a int, b int) []byte {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data, uint64(a))
+	binary.BigEndian.PutUint64(data[8:], uint64(b))
+	sum := sha256.Sum256(append([]byte(kind), data...))
+	return sum[:]
+}
+
+//the tool prints progress lines, which would dominate the timings
+func silenceStdout(b *testing.B) func() {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	return func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}
+}
+
+//run fn and return its duration and the peak heap in use while it runs, sampled every millisecond
+func measure(fn func()) (time.Duration, uint64) {
+	runtime.GC()
+	var peak uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		var stats runtime.MemStats
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > peak {
+				peak = stats.HeapInuse
+			}
+			select {
+			case <-done:
+				close(sampled)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	start := time.Now()
+	fn()
+	elapsed := time.Since(start)
+	close(done)
+	<-sampled
+	return elapsed, peak
+}
+
+//report the utxos per second over the measured runs and the largest peak heap
+func reportMetrics(b *testing.B, utxos int, elapsed time.Duration, peak uint64) {
+	if elapsed > 0 {
+		b.ReportMetric(float64(utxos)*float64(b.N)/elapsed.Seconds(), "utxos/s")
+	}
+	b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+}
+
+func BenchmarkGetOldUtxoIndexFromDB(b *testing.B) {
+	dir, err := ioutil.TempDir("", "utxo_upgrade_bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbfilename := writeSyntheticUtxoLists(b, dir, *synthLists, *synthUtxos)
+	defer silenceStdout(b)()
+	var total time.Duration
+	var peak uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var oldUtxoIndex OldUtxoIndex
+		elapsed, heap := measure(func() {
+			oldUtxoIndex, err = getOldUtxoIndexFromDB(context.Background(), dbfilename)
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(oldUtxoIndex.PublicKey) != *synthLists {
+			b.Fatalf("%d utxo lists are read, expected %d", len(oldUtxoIndex.PublicKey), *synthLists)
+		}
+		total += elapsed
+		if heap > peak {
+			peak = heap
+		}
+	}
+	reportMetrics(b, *synthLists**synthUtxos, total, peak)
+}
+
+//the conversion of the lists without the backup that ConvertAndSaveUtxoIndexToDB makes first
+func BenchmarkConvertAndSaveUtxoIndexToDB(b *testing.B) {
+	defer silenceStdout(b)()
+	var total time.Duration
+	var peak uint64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dir, err := ioutil.TempDir("", "utxo_upgrade_bench")
+		if err != nil {
+			b.Fatal(err)
+		}
+		dbfilename := writeSyntheticUtxoLists(b, dir, *synthLists, *synthUtxos)
+		oldUtxoIndex, err := getOldUtxoIndexFromDB(context.Background(), dbfilename)
+		if err != nil {
+			b.Fatal(err)
+		}
+		db := storage.OpenDatabase(dbfilename)
+		b.StartTimer()
+		elapsed, heap := measure(func() {
+			_, _, err = convertUtxoIndexInDB(context.Background(), db, oldUtxoIndex)
+		})
+		b.StopTimer()
+		db.Close()
+		os.RemoveAll(dir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		total += elapsed
+		if heap > peak {
+			peak = heap
+		}
+	}
+	reportMetrics(b, *synthLists**synthUtxos, total, peak)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dappley/go-dappley/core/utxo"
+	newutxopb "github.com/dappley/go-dappley/core/utxo/pb"
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var (
+	ErrUtxoMissing = errors.New("utxo in the chain is missing")
+	ErrUtxoCycle   = errors.New("utxo chain has a cycle")
+)
+
+//pubkey head of an address chain in the v0.5 layout, the chain goes from the head through NextUtxoKey
+type utxoHead struct {
+	pubkey  string
+	lastKey []byte
+	//the node stores heads as UtxoInfo, this tool as the raw last utxo key
+	info *newutxopb.UtxoInfo
+}
+
+//decode a pubkey head. The last utxo key is not required to exist, so that heads pointing to
+//missing utxos are still recognized
+func decodeUtxoHead(db *leveldb.DB, pubkey string, value []byte) (*utxoHead, bool) {
+	if isExistingUtxoKey(db, value) {
+		return &utxoHead{pubkey: pubkey, lastKey: value}, true
+	}
+	utxoInfo := &newutxopb.UtxoInfo{}
+	err := proto.Unmarshal(value, utxoInfo)
+	if err == nil && isUtxoKeyShape(utxoInfo.GetLastUtxoKey()) {
+		return &utxoHead{pubkey: pubkey, lastKey: utxoInfo.GetLastUtxoKey(), info: utxoInfo}, true
+	}
+	if isUtxoKeyShape(value) {
+		return &utxoHead{pubkey: pubkey, lastKey: value}, true
+	}
+	return nil, false
+}
+
+//encode the head in the format it was read in
+func (h *utxoHead) encode() ([]byte, error) {
+	if h.info == nil {
+		return h.lastKey, nil
+	}
+	h.info.LastUtxoKey = h.lastKey
+	return proto.Marshal(h.info)
+}
+
+func decodeUtxoRecord(key []byte, value []byte) (*utxo.UTXO, bool) {
+	if !isValidUtxoKeyValue(key, value) {
+		return nil, false
+	}
+	utxoPb := &newutxopb.Utxo{}
+	if proto.Unmarshal(value, utxoPb) != nil {
+		return nil, false
+	}
+	record := &utxo.UTXO{}
+	record.FromProto(utxoPb)
+	return record, true
+}
+
+func encodeUtxoRecord(record *utxo.UTXO) ([]byte, error) {
+	return proto.Marshal(record.ToProto().(*newutxopb.Utxo))
+}
+
+func isExistingUtxoKey(db *leveldb.DB, key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+	value, err := db.Get(key, nil)
+	return err == nil && isValidUtxoKeyValue(key, value)
+}
+
+//walk an address chain from its head through NextUtxoKey over the loaded utxo records, and
+//return the keys in chain order. On a broken chain, the keys up to the break are returned
+func walkUtxoChain(records map[string]*utxo.UTXO, head *utxoHead) ([]string, error) {
+	var keys []string
+	visited := map[string]bool{}
+	for key := head.lastKey; len(key) != 0; {
+		keyStr := string(key)
+		if visited[keyStr] {
+			return keys, fmt.Errorf("%w: %s", ErrUtxoCycle, utxoKeyString(key))
+		}
+		record, ok := records[keyStr]
+		if !ok {
+			return keys, fmt.Errorf("%w: %s", ErrUtxoMissing, utxoKeyString(key))
+		}
+		visited[keyStr] = true
+		keys = append(keys, keyStr)
+		key = record.NextUtxoKey
+	}
+	return keys, nil
+}
+
+//readable form of a utxo key, txid in hex + "_" + output index
+func utxoKeyString(key []byte) string {
+	sep := bytes.LastIndexByte(key, '_')
+	if sep < 0 {
+		return hex.EncodeToString(key)
+	}
+	return hex.EncodeToString(key[:sep]) + string(key[sep:])
+}
+
+//all v0.5 utxo records and pubkey heads of a database, and the keys of its v0.3 utxo lists
+type utxoState struct {
+	records map[string]*utxo.UTXO
+	heads   map[string]*utxoHead
+	//pubkey string -> key of the v0.3 utxo list
+	oldLists map[string][]byte
+}
+
+func loadUtxoState(db *leveldb.DB) (*utxoState, error) {
+	state := &utxoState{
+		records:  map[string]*utxo.UTXO{},
+		heads:    map[string]*utxoHead{},
+		oldLists: map[string][]byte{},
+	}
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := append([]byte{}, iter.Key()...)
+		value := append([]byte{}, iter.Value()...)
+		switch classifyKeyValue(db, key, value) {
+		case categoryUtxoV5:
+			record, _ := decodeUtxoRecord(key, value)
+			state.records[string(key)] = record
+		case categoryUtxoHead:
+			head, _ := decodeUtxoHead(db, string(key), value)
+			state.heads[head.pubkey] = head
+		case categoryUtxoListV3:
+			state.oldLists[hex.EncodeToString(key)] = key
+		}
+	}
+	return state, iter.Error()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+
+	blockpb "github.com/dappley/go-dappley/core/block/pb"
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+//category of a key-value pair in a node database
+type keyCategory int
+
+const (
+	categoryBlock keyCategory = iota
+	categoryHeightIndex
+	categoryTailHash
+	categoryUtxoListV3
+	categoryUtxoV5
+	categoryUtxoHead
+	categoryJournal
+	categoryQuarantine
+	categoryUnknown
+)
+
+//all categories in the order they are reported
+var categoryList = []keyCategory{
+	categoryBlock,
+	categoryHeightIndex,
+	categoryTailHash,
+	categoryUtxoListV3,
+	categoryUtxoV5,
+	categoryUtxoHead,
+	categoryJournal,
+	categoryQuarantine,
+	categoryUnknown,
+}
+
+var categoryNames = map[keyCategory]string{
+	categoryBlock:       "block",
+	categoryHeightIndex: "height index",
+	categoryTailHash:    "tail block hash",
+	categoryUtxoListV3:  "v0.3 utxo list",
+	categoryUtxoV5:      "v0.5 utxo",
+	categoryUtxoHead:    "v0.5 pubkey head",
+	categoryJournal:     "migration journal",
+	categoryQuarantine:  "quarantined list",
+	categoryUnknown:     "unknown",
+}
+
+var tipKey = []byte("tailBlockHash")
+
+func (c keyCategory) String() string {
+	return categoryNames[c]
+}
+
+//classify a key-value pair, db is used to check the keys that heads and height indexes point to
+func classifyKeyValue(db *leveldb.DB, key []byte, value []byte) keyCategory {
+	if bytes.Equal(key, tipKey) {
+		return categoryTailHash
+	}
+	if bytes.HasPrefix(key, journalPrefix) {
+		return categoryJournal
+	}
+	if bytes.HasPrefix(key, quarantinePrefix) {
+		return categoryQuarantine
+	}
+	if isValidUtxoKeyValue(key, value) {
+		return categoryUtxoV5
+	}
+	if isBlockKeyValue(key, value) {
+		return categoryBlock
+	}
+	if isHeightIndexKeyValue(db, key, value) {
+		return categoryHeightIndex
+	}
+	if isUtxoHeadKeyValue(db, key, value) {
+		return categoryUtxoHead
+	}
+	err, utxotxold := DeserializeUTXOTx(value)
+	if err == nil && len(utxotxold.Key) != 0 && len(utxotxold.UTXO) != 0 && isValidUtxotx(key, utxotxold) {
+		return categoryUtxoListV3
+	}
+	return categoryUnknown
+}
+
+//a block is stored with its own hash as the key
+func isBlockKeyValue(key []byte, value []byte) bool {
+	blockPb := &blockpb.Block{}
+	err := proto.Unmarshal(value, blockPb)
+	if err != nil {
+		return false
+	}
+	return len(key) != 0 && bytes.Equal(blockPb.GetHeader().GetHash(), key)
+}
+
+//the height index maps util.UintToHex(height), a big endian uint64, to the block hash
+func isHeightIndexKeyValue(db *leveldb.DB, key []byte, value []byte) bool {
+	if len(key) != 8 || len(value) == 0 {
+		return false
+	}
+	rawBytes, err := db.Get(value, nil)
+	if err != nil {
+		return false
+	}
+	blockPb := &blockpb.Block{}
+	if proto.Unmarshal(rawBytes, blockPb) != nil {
+		return false
+	}
+	return blockPb.GetHeader().GetHeight() == binary.BigEndian.Uint64(key)
+}
+
+//a pubkey head is stored with the hex string of the pubkey hash as the key
+func isUtxoHeadKeyValue(db *leveldb.DB, key []byte, value []byte) bool {
+	if len(key) == 0 || len(key)%2 != 0 {
+		return false
+	}
+	if _, err := hex.DecodeString(string(key)); err != nil {
+		return false
+	}
+	_, ok := decodeUtxoHead(db, string(key), value)
+	return ok
+}
+
+//a utxo key is txid + "_" + output index, see utxo.GetUTXOKey
+func isUtxoKeyShape(key []byte) bool {
+	sep := bytes.LastIndexByte(key, '_')
+	if sep <= 0 || sep == len(key)-1 {
+		return false
+	}
+	_, err := strconv.Atoi(string(key[sep+1:]))
+	return err == nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dappley/go-dappley/common"
+	"github.com/dappley/go-dappley/core/account"
+	blockpb "github.com/dappley/go-dappley/core/block/pb"
+	newutxopb "github.com/dappley/go-dappley/core/utxo/pb"
+	oldutxopb "github.com/dappley/go-dappley/tool/utxo_structure_upgrade/oldpb"
+	"github.com/dappley/go-dappley/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func newMemDB(t *testing.T) *leveldb.DB {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	rawBytes, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rawBytes
+}
+
+func TestClassifyKeyValue(t *testing.T) {
+	db := newMemDB(t)
+	defer db.Close()
+
+	pubKeyHash := append([]byte{0x5A}, bytes.Repeat([]byte{0x01}, 20)...)
+	otherPubKeyHash := append([]byte{0x5A}, bytes.Repeat([]byte{0x02}, 20)...)
+	txid := bytes.Repeat([]byte{0x11}, 32)
+	utxoKey := append(append([]byte{}, txid...), []byte("_0")...)
+	utxoValue := mustMarshal(t, &newutxopb.Utxo{
+		Amount:        common.NewAmount(10).Bytes(),
+		PublicKeyHash: pubKeyHash,
+		Txid:          txid,
+		TxIndex:       0,
+	})
+	blockHash := bytes.Repeat([]byte{0x22}, 32)
+	blockValue := mustMarshal(t, &blockpb.Block{Header: &blockpb.BlockHeader{Hash: blockHash, Height: 3}})
+	//0xff bytes never parse as a protobuf message
+	missingHash := bytes.Repeat([]byte{0xff}, 32)
+	oldList := func(owner []byte) []byte {
+		return mustMarshal(t, &oldutxopb.UtxoList{Utxos: []*oldutxopb.Utxo{{
+			Amount:        common.NewAmount(10).Bytes(),
+			PublicKeyHash: owner,
+			Txid:          txid,
+			TxIndex:       1,
+		}}})
+	}
+	//the heads and the height index are only recognized when what they point to exists
+	for key, value := range map[string][]byte{string(utxoKey): utxoValue, string(blockHash): blockValue} {
+		if err := db.Put([]byte(key), value, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		key   []byte
+		value []byte
+		want  keyCategory
+	}{
+		{"tail block hash", tipKey, blockHash, categoryTailHash},
+		{"journal record", append(append([]byte{}, journalPrefix...), 0x01), []byte("{}"), categoryJournal},
+		{"quarantined list", append(append([]byte{}, quarantinePrefix...), pubKeyHash...), []byte("{}"), categoryQuarantine},
+		{"v0.5 utxo", utxoKey, utxoValue, categoryUtxoV5},
+		{"v0.5 utxo under another key", append(append([]byte{}, txid...), []byte("_1")...), utxoValue, categoryUnknown},
+		{"block", blockHash, blockValue, categoryBlock},
+		{"block under another hash", missingHash, blockValue, categoryUnknown},
+		{"height index", util.UintToHex(3), blockHash, categoryHeightIndex},
+		{"height index of another height", util.UintToHex(4), blockHash, categoryUnknown},
+		{"height index of a missing block", util.UintToHex(3), missingHash, categoryUnknown},
+		{"head as the last utxo key", []byte(account.PubKeyHash(pubKeyHash).String()), utxoKey, categoryUtxoHead},
+		{"head as utxo info", []byte(account.PubKeyHash(pubKeyHash).String()), mustMarshal(t, &newutxopb.UtxoInfo{LastUtxoKey: utxoKey}), categoryUtxoHead},
+		{"head with a key that is not hex", []byte("not a pubkey"), utxoKey, categoryUnknown},
+		{"v0.3 utxo list", pubKeyHash, oldList(pubKeyHash), categoryUtxoListV3},
+		{"v0.3 utxo list of another pubkey", pubKeyHash, oldList(otherPubKeyHash), categoryUnknown},
+		{"empty v0.3 utxo list", pubKeyHash, mustMarshal(t, &oldutxopb.UtxoList{}), categoryUnknown},
+		{"garbage", []byte("some key"), missingHash, categoryUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyKeyValue(db, tt.key, tt.value)
+			if got != tt.want {
+				t.Errorf("classifyKeyValue() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
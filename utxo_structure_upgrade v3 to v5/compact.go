@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+//on-disk size and file counts of a database directory
+type dbStats struct {
+	Size   int64
+	Files  int
+	Tables int
+	Logs   int
+}
+
+func compactCmdHandler(flags cmdFlags) {
+	dbfilename := *(flags[flagDatabase].(*string))
+	force := *(flags[flagForce].(*bool))
+
+	if !isDbExist(dbfilename) {
+		logger.WithError(ErrDBNotFound).Error("Cannot find such file in the directory!")
+		return
+	}
+	lock, err := acquireDBLock(dbfilename, force)
+	if err != nil {
+		logger.WithError(err).Error("Cannot lock the database!")
+		return
+	}
+	defer lock.release()
+	compactDB(dbfilename)
+}
+
+//run a full range compaction to drop the tombstones of the deleted keys, and report the size before
+//and after. The caller holds the tool lock
+func compactDB(dbfilename string) {
+	before, err := getDBStats(dbfilename)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read the database directory!")
+		return
+	}
+	db, err := leveldb.OpenFile(dbfilename, nil)
+	if err != nil {
+		logger.WithError(err).Error("failed to open db!")
+		return
+	}
+	fmt.Println("Compacting the database......")
+	err = db.CompactRange(util.Range{})
+	closeErr := db.Close()
+	if err != nil {
+		logger.WithError(err).Error("Failed to compact the database!")
+		return
+	}
+	if closeErr != nil {
+		logger.WithError(closeErr).Error("Failed to close the database!")
+		return
+	}
+	after, err := getDBStats(dbfilename)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read the database directory!")
+		return
+	}
+	result.Data = map[string]dbStats{"before": before, "after": after}
+	printDBStats(before, after)
+}
+
+func getDBStats(dir string) (dbStats, error) {
+	var stats dbStats
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		stats.Size += info.Size()
+		stats.Files++
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ldb", ".sst":
+			stats.Tables++
+		case ".log":
+			stats.Logs++
+		}
+		return nil
+	})
+	return stats, err
+}
+
+func printDBStats(before dbStats, after dbStats) {
+	fmt.Printf("%-8s %14s %6s %7s %5s\n", "", "SIZE", "FILES", "TABLES", "LOGS")
+	fmt.Printf("%-8s %14d %6d %7d %5d\n", "before", before.Size, before.Files, before.Tables, before.Logs)
+	fmt.Printf("%-8s %14d %6d %7d %5d\n", "after", after.Size, after.Files, after.Tables, after.Logs)
+	fmt.Printf("The database size changed by %d bytes\n", after.Size-before.Size)
+}
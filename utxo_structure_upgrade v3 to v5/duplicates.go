@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/dappley/go-dappley/core/account"
+	logger "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var (
+	ErrUtxoDuplicate         = errors.New("utxo key appears more than once")
+	ErrUtxoDuplicateConflict = errors.New("utxo key appears more than once with different values")
+)
+
+//a utxo key that appears more than once in the v0.3 utxo list of a pubkey. Identical copies are
+//merged by the upgrade, a list with conflicting copies is quarantined
+type duplicateUtxo struct {
+	Pubkey string `json:"pubkey"`
+	//hex of the txid and the vout index
+	Key      string `json:"key"`
+	Count    int    `json:"count"`
+	Conflict bool   `json:"conflict"`
+}
+
+func duplicatesCmdHandler(flags cmdFlags) {
+	dbfilename := *(flags[flagDatabase].(*string))
+	readOnly := *(flags[flagReadOnly].(*bool))
+
+	if !isDbExist(dbfilename) {
+		logger.WithError(ErrDBNotFound).Error("Cannot find such file in the directory!")
+		return
+	}
+	db, err := leveldb.OpenFile(dbfilename, &opt.Options{ReadOnly: readOnly})
+	if err != nil {
+		logger.WithError(err).Error("failed to open db!")
+		return
+	}
+	defer db.Close()
+
+	lists := 0
+	var duplicates []duplicateUtxo
+	iter := db.NewIterator(nil, nil)
+	for iter.Next() {
+		curKey := iter.Key()
+		curValue := iter.Value()
+		err, utxotxold := DeserializeUTXOTx(curValue)
+		if err != nil || len(utxotxold.Key) == 0 || len(utxotxold.UTXO) == 0 || isValidUtxoKeyValue(curKey, curValue) {
+			continue
+		}
+		if validateUtxotx(curKey, utxotxold) != nil && !isUtxotxOfKey(curKey, utxotxold) {
+			continue
+		}
+		lists++
+		_, found, _ := mergeDuplicateUtxos(account.PubKeyHash(curKey).String(), utxotxold)
+		duplicates = append(duplicates, found...)
+	}
+	iter.Release()
+	err = iter.Error()
+	if err != nil {
+		logger.WithError(err).Error("Iter error!")
+		return
+	}
+
+	conflicts := 0
+	for _, d := range duplicates {
+		if d.Conflict {
+			conflicts++
+		}
+	}
+	result.Counts["utxo_lists"] = lists
+	result.Counts["duplicates"] = len(duplicates)
+	result.Counts["conflicts"] = conflicts
+	result.Data = duplicates
+	printDuplicateUtxos(duplicates)
+	fmt.Printf("%d of the duplicate keys in %d v0.3 utxo lists have conflicting values\n", conflicts, lists)
+	if len(duplicates) > 0 {
+		setExitCode(exitCorruption)
+	}
+}
+
+//drop the copies of a utxo key that are identical to the first one, the order of the first copies
+//is kept. Copies that differ are an ErrUtxoDuplicateConflict and nothing is dropped
+func mergeDuplicateUtxos(pubkey string, utxotxold UTXOTxOld) (UTXOTxOld, []duplicateUtxo, error) {
+	if len(utxotxold.Key) != len(utxotxold.UTXO) {
+		return utxotxold, nil, fmt.Errorf("%w: %d keys, %d utxos", ErrUtxoLengthMismatch, len(utxotxold.Key), len(utxotxold.UTXO))
+	}
+	first := map[string]int{}
+	index := map[string]int{}
+	var duplicates []duplicateUtxo
+	merged := NewUTXOTxOld()
+	for i, key := range utxotxold.Key {
+		j, seen := first[key]
+		if !seen {
+			first[key] = i
+			merged.Key = append(merged.Key, key)
+			merged.UTXO = append(merged.UTXO, utxotxold.UTXO[i])
+			continue
+		}
+		d, reported := index[key]
+		if !reported {
+			d = len(duplicates)
+			index[key] = d
+			duplicates = append(duplicates, duplicateUtxo{Pubkey: pubkey, Key: utxoKeyHex(utxotxold.UTXO[j]), Count: 1})
+		}
+		duplicates[d].Count++
+		if !isSameOldUtxo(utxotxold.UTXO[j], utxotxold.UTXO[i]) {
+			duplicates[d].Conflict = true
+		}
+	}
+	for _, d := range duplicates {
+		if d.Conflict {
+			return utxotxold, duplicates, fmt.Errorf("%w: %s", ErrUtxoDuplicateConflict, d.Key)
+		}
+	}
+	return merged, duplicates, nil
+}
+
+func isSameOldUtxo(a *OldUTXO, b *OldUTXO) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if (a.Value == nil) != (b.Value == nil) || (a.Value != nil && a.Value.Cmp(b.Value) != 0) {
+		return false
+	}
+	return bytes.Equal(a.PubKeyHash, b.PubKeyHash) && a.UtxoType == b.UtxoType && a.Contract == b.Contract
+}
+
+func utxoKeyHex(u *OldUTXO) string {
+	if u == nil {
+		return ""
+	}
+	return hex.EncodeToString(u.Txid) + "_" + strconv.Itoa(u.TxIndex)
+}
+
+func printDuplicateUtxos(duplicates []duplicateUtxo) {
+	if len(duplicates) == 0 {
+		fmt.Println("There are no duplicate utxos in the v0.3 utxo lists")
+		return
+	}
+	for _, d := range duplicates {
+		kind := "identical"
+		if d.Conflict {
+			kind = "conflicting"
+		}
+		fmt.Printf("%s  %s  %d copies, %s\n", d.Pubkey, d.Key, d.Count, kind)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/dappley/go-dappley/common"
+	"github.com/dappley/go-dappley/core/transactionbase"
+)
+
+func TestMergeDuplicateUtxos(t *testing.T) {
+	pubKeyHash := []byte{0x5A, 0x01}
+	newUtxo := func(txid byte, value uint64, contract string) *OldUTXO {
+		return &OldUTXO{
+			TXOutput: transactionbase.TXOutput{Value: common.NewAmount(value), PubKeyHash: pubKeyHash, Contract: contract},
+			Txid:     []byte{txid},
+		}
+	}
+	list := func(utxos ...*OldUTXO) UTXOTxOld {
+		utxotxold := NewUTXOTxOld()
+		for _, u := range utxos {
+			utxotxold.PutUtxo(u)
+		}
+		return utxotxold
+	}
+	a, b, c := newUtxo(1, 10, ""), newUtxo(2, 20, ""), newUtxo(3, 30, "")
+
+	tests := []struct {
+		name       string
+		list       UTXOTxOld
+		want       UTXOTxOld
+		duplicates []duplicateUtxo
+		wantErr    error
+	}{
+		{
+			name: "no duplicates",
+			list: list(a, b, c),
+			want: list(a, b, c),
+		},
+		{
+			name:       "identical copy",
+			list:       list(a, b, newUtxo(1, 10, ""), c),
+			want:       list(a, b, c),
+			duplicates: []duplicateUtxo{{Key: utxoKeyHex(a), Count: 2}},
+		},
+		{
+			name: "several identical copies",
+			list: list(b, a, newUtxo(2, 20, ""), newUtxo(1, 10, ""), newUtxo(2, 20, "")),
+			want: list(b, a),
+			duplicates: []duplicateUtxo{
+				{Key: utxoKeyHex(b), Count: 3},
+				{Key: utxoKeyHex(a), Count: 2},
+			},
+		},
+		{
+			name:       "copy with another value",
+			list:       list(a, b, newUtxo(1, 11, "")),
+			want:       list(a, b, newUtxo(1, 11, "")),
+			duplicates: []duplicateUtxo{{Key: utxoKeyHex(a), Count: 2, Conflict: true}},
+			wantErr:    ErrUtxoDuplicateConflict,
+		},
+		{
+			name:       "copy with another contract",
+			list:       list(a, newUtxo(1, 10, "contract")),
+			want:       list(a, newUtxo(1, 10, "contract")),
+			duplicates: []duplicateUtxo{{Key: utxoKeyHex(a), Count: 2, Conflict: true}},
+			wantErr:    ErrUtxoDuplicateConflict,
+		},
+		{
+			name:    "keys and utxos do not match",
+			list:    UTXOTxOld{Key: list(a, b).Key, UTXO: []*OldUTXO{a}},
+			want:    UTXOTxOld{Key: list(a, b).Key, UTXO: []*OldUTXO{a}},
+			wantErr: ErrUtxoLengthMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.duplicates {
+				tt.duplicates[i].Pubkey = "pubkey"
+			}
+			got, duplicates, err := mergeDuplicateUtxos("pubkey", tt.list)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("mergeDuplicateUtxos() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeDuplicateUtxos() kept %d utxos %q, want %d utxos %q", len(got.UTXO), got.Key, len(tt.want.UTXO), tt.want.Key)
+			}
+			if len(duplicates) != 0 || len(tt.duplicates) != 0 {
+				if !reflect.DeepEqual(duplicates, tt.duplicates) {
+					t.Errorf("duplicates = %+v, want %+v", duplicates, tt.duplicates)
+				}
+			}
+		})
+	}
+}
+
+//a list with a duplicate that is not merged cannot be linked, its utxo would link to itself
+func TestLinkUtxosDuplicate(t *testing.T) {
+	utxotxold := NewUTXOTxOld()
+	u := &OldUTXO{
+		TXOutput: transactionbase.TXOutput{Value: common.NewAmount(10), PubKeyHash: []byte{0x5A, 0x01}},
+		Txid:     []byte{1},
+	}
+	utxotxold.PutUtxo(u)
+	utxotxold.PutUtxo(u)
+	utxotx, err := utxotxold.ConvertUtxotx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = linkUtxos(utxotx)
+	if !errors.Is(err, ErrUtxoDuplicate) {
+		t.Fatalf("linkUtxos() error = %v, want %v", err, ErrUtxoDuplicate)
+	}
+}
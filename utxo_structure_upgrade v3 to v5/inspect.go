@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"unicode"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+//statistics of one key category
+type categoryStats struct {
+	Count      uint64
+	TotalBytes uint64
+	Examples   []string
+}
+
+func (s *categoryStats) meanBytes() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.TotalBytes) / float64(s.Count)
+}
+
+func inspectCmdHandler(flags cmdFlags) {
+	dbfilename := *(flags[flagDatabase].(*string))
+	examples := *(flags[flagExamples].(*uint64))
+	readOnly := *(flags[flagReadOnly].(*bool))
+
+	if !isDbExist(dbfilename) {
+		logger.WithError(ErrDBNotFound).Error("Cannot find such file in the directory!")
+		return
+	}
+	db, err := leveldb.OpenFile(dbfilename, &opt.Options{ReadOnly: readOnly})
+	if err != nil {
+		logger.WithError(err).Error("failed to open db!")
+		return
+	}
+	defer db.Close()
+
+	stats, err := inspectDB(db, int(examples))
+	if err != nil {
+		logger.WithError(err).Error("Iter error!")
+		return
+	}
+	named := map[string]*categoryStats{}
+	for c, s := range stats {
+		result.Counts[c.String()] = int(s.Count)
+		named[c.String()] = s
+	}
+	result.Data = named
+	printInspectStats(dbfilename, stats)
+}
+
+//iterate all keys of the database and collect the statistics of each category
+func inspectDB(db *leveldb.DB, examples int) (map[keyCategory]*categoryStats, error) {
+	stats := map[keyCategory]*categoryStats{}
+	for _, c := range categoryList {
+		stats[c] = &categoryStats{}
+	}
+
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		category := classifyKeyValue(db, iter.Key(), iter.Value())
+		s := stats[category]
+		s.Count++
+		s.TotalBytes += uint64(len(iter.Value()))
+		if len(s.Examples) < examples {
+			s.Examples = append(s.Examples, printableKey(iter.Key()))
+		}
+	}
+	return stats, iter.Error()
+}
+
+func printInspectStats(dbfilename string, stats map[keyCategory]*categoryStats) {
+	fmt.Println("Key space of", dbfilename)
+	fmt.Printf("%-18s %10s %14s %12s\n", "CATEGORY", "COUNT", "VALUE BYTES", "MEAN BYTES")
+	for _, c := range categoryList {
+		s := stats[c]
+		fmt.Printf("%-18s %10d %14d %12.1f\n", c, s.Count, s.TotalBytes, s.meanBytes())
+	}
+	for _, c := range categoryList {
+		s := stats[c]
+		if len(s.Examples) == 0 {
+			continue
+		}
+		fmt.Printf("\nExample keys of %s:\n", c)
+		for _, key := range s.Examples {
+			fmt.Println(" ", key)
+		}
+	}
+}
+
+//print keys made of printable characters as they are, and others in hex
+func printableKey(key []byte) string {
+	for _, r := range string(key) {
+		if r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return "0x" + hex.EncodeToString(key)
+		}
+	}
+	return fmt.Sprintf("%q", key)
+}
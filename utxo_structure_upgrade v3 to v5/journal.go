@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+//set at build time with -ldflags "-X main.version=<version>"
+var version = "dev"
+
+const toolName = "utxo_upgrade"
+
+//reserved key prefix of the journal records, utxo_generator writes its records under the same prefix
+var journalPrefix = []byte("migrationJournal_")
+
+//utxo schema versions
+const (
+	schemaV3 = "v0.3.0"
+	schemaV5 = "v0.5.0"
+)
+
+//outcomes of a run
+const (
+	outcomeRunning     = "running"
+	outcomeSuccess     = "success"
+	outcomeFailed      = "failed"
+	outcomeInterrupted = "interrupted"
+)
+
+//one run of a tool that modifies the db
+type journalRecord struct {
+	Tool     string         `json:"tool"`
+	Version  string         `json:"version"`
+	Command  string         `json:"command"`
+	Args     []string       `json:"args"`
+	Started  time.Time      `json:"started"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Source   string         `json:"source_schema,omitempty"`
+	Target   string         `json:"target_schema,omitempty"`
+	Counts   map[string]int `json:"counts"`
+	Outcome  string         `json:"outcome"`
+	Error    string         `json:"error,omitempty"`
+}
+
+type journal struct {
+	key    []byte
+	record journalRecord
+}
+
+//the records are ordered by their start time
+func journalKey(started time.Time) []byte {
+	key := append([]byte{}, journalPrefix...)
+	nanos := make([]byte, 8)
+	binary.BigEndian.PutUint64(nanos, uint64(started.UnixNano()))
+	return append(key, nanos...)
+}
+
+//a running record, it has to be saved before the db is changed so that an interrupted run leaves a
+//trace. Commands that do not change the utxo schema leave source and target empty
+func newJournal(command string, source string, target string) *journal {
+	started := time.Now().UTC()
+	return &journal{
+		key: journalKey(started),
+		record: journalRecord{
+			Tool:    toolName,
+			Version: version,
+			Command: command,
+			Args:    os.Args[1:],
+			Started: started,
+			Source:  source,
+			Target:  target,
+			Counts:  map[string]int{},
+			Outcome: outcomeRunning,
+		},
+	}
+}
+
+func (jr *journal) count(name string, n int) {
+	jr.record.Counts[name] += n
+}
+
+//record the end time and the outcome of the run, the record still has to be saved. The counts are
+//reported in the command result too
+func (jr *journal) finish(err error) {
+	for name, n := range jr.record.Counts {
+		result.Counts[name] = n
+	}
+	finished := time.Now().UTC()
+	jr.record.Finished = &finished
+	jr.record.Outcome = outcomeSuccess
+	if err != nil {
+		jr.record.Outcome = outcomeFailed
+		if errors.Is(err, ErrInterrupted) {
+			jr.record.Outcome = outcomeInterrupted
+		}
+		jr.record.Error = err.Error()
+	}
+}
+
+func (jr *journal) save(db *leveldb.DB) error {
+	rawBytes, err := json.Marshal(jr.record)
+	if err != nil {
+		return err
+	}
+	return db.Put(jr.key, rawBytes, nil)
+}
+
+//save the record for commands that do not keep the db open
+func (jr *journal) saveToFile(dbfilename string) error {
+	db, err := leveldb.OpenFile(dbfilename, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return jr.save(db)
+}
+
+func journalCmdHandler(flags cmdFlags) {
+	dbfilename := *(flags[flagDatabase].(*string))
+	readOnly := *(flags[flagReadOnly].(*bool))
+
+	if !isDbExist(dbfilename) {
+		logger.WithError(ErrDBNotFound).Error("Cannot find such file in the directory!")
+		return
+	}
+	db, err := leveldb.OpenFile(dbfilename, &opt.Options{ReadOnly: readOnly})
+	if err != nil {
+		logger.WithError(err).Error("failed to open db!")
+		return
+	}
+	defer db.Close()
+	records, err := getJournalRecords(db)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read the journal!")
+		return
+	}
+	result.Counts["records"] = len(records)
+	if jsonOutput {
+		result.Data = records
+		return
+	}
+	printJournalRecords(records)
+}
+
+func getJournalRecords(db *leveldb.DB) ([]journalRecord, error) {
+	var records []journalRecord
+	iter := db.NewIterator(util.BytesPrefix(journalPrefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var record journalRecord
+		err := json.Unmarshal(iter.Value(), &record)
+		if err != nil {
+			return nil, fmt.Errorf("record %x: %w", iter.Key(), err)
+		}
+		records = append(records, record)
+	}
+	return records, iter.Error()
+}
+
+func printJournalRecords(records []journalRecord) {
+	if len(records) == 0 {
+		fmt.Println("The journal is empty")
+		return
+	}
+	for _, record := range records {
+		finished := "-"
+		if record.Finished != nil {
+			finished = record.Finished.Format(time.RFC3339)
+		}
+		fmt.Printf("%s  %s %s %s  %s\n", record.Started.Format(time.RFC3339), record.Tool, record.Version,
+			record.Command, record.Outcome)
+		fmt.Println("  args:", strings.Join(record.Args, " "))
+		fmt.Println("  finished:", finished)
+		if record.Source != "" || record.Target != "" {
+			fmt.Printf("  schema: %s -> %s\n", record.Source, record.Target)
+		}
+		var names []string
+		for name := range record.Counts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %s: %d\n", name, record.Counts[name])
+		}
+		if record.Error != "" {
+			fmt.Println("  error:", record.Error)
+		}
+	}
+}
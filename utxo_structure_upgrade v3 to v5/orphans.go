@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/dappley/go-dappley/core/utxo"
+	logger "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var ErrConflictingFlags = errors.New("conflicting flags")
+
+//utxo records that no pubkey head reaches, and v0.3 utxo lists left next to converted chains
+type orphanReport struct {
+	orphans []string
+	//pubkeys that have both a v0.5 head and a v0.3 utxo list
+	leftovers []string
+	//pubkey -> error of the chains that could not be walked to the end
+	broken map[string]error
+}
+
+func orphansCmdHandler(flags cmdFlags) {
+	dbfilename := *(flags[flagDatabase].(*string))
+	force := *(flags[flagForce].(*bool))
+	deleteOrphans := *(flags[flagDelete].(*bool))
+	relink := *(flags[flagRelink].(*bool))
+	readOnly := *(flags[flagReadOnly].(*bool))
+
+	if deleteOrphans && relink {
+		logger.WithError(ErrConflictingFlags).Error("Use either -delete or -relink!")
+		return
+	}
+	if readOnly && (deleteOrphans || relink) {
+		logger.WithError(ErrConflictingFlags).Error("-readonly cannot be used with -delete or -relink!")
+		return
+	}
+	if !isDbExist(dbfilename) {
+		logger.WithError(ErrDBNotFound).Error("Cannot find such file in the directory!")
+		return
+	}
+	if deleteOrphans || relink {
+		lock, err := acquireDBLock(dbfilename, force)
+		if err != nil {
+			logger.WithError(err).Error("Cannot lock the database!")
+			return
+		}
+		defer lock.release()
+	}
+	//the report alone only reads the database
+	db, err := leveldb.OpenFile(dbfilename, &opt.Options{ReadOnly: !deleteOrphans && !relink})
+	if err != nil {
+		logger.WithError(err).Error("failed to open db!")
+		return
+	}
+	defer db.Close()
+
+	state, err := loadUtxoState(db)
+	if err != nil {
+		logger.WithError(err).Error("Iter error!")
+		return
+	}
+	report := findOrphans(state)
+	printOrphanReport(state, report)
+	result.Counts["orphans"] = len(report.orphans)
+	result.Counts["leftover_lists"] = len(report.leftovers)
+	result.Counts["broken_chains"] = len(report.broken)
+
+	batch := new(leveldb.Batch)
+	switch {
+	case deleteOrphans:
+		for _, key := range report.orphans {
+			batch.Delete([]byte(key))
+		}
+		for _, pubkey := range report.leftovers {
+			batch.Delete(state.oldLists[pubkey])
+		}
+	case relink:
+		relinked, err := relinkOrphans(state, report, batch)
+		if err != nil {
+			logger.WithError(err).Error("Failed to relink orphan utxos!")
+			return
+		}
+		fmt.Println("The number of relinked utxos is ", relinked)
+	default:
+		if len(report.orphans) > 0 || len(report.leftovers) > 0 || len(report.broken) > 0 {
+			setExitCode(exitCorruption)
+		}
+		return
+	}
+	jr := newJournal(orphans, "", "")
+	err = jr.save(db)
+	if err != nil {
+		logger.WithError(err).Error("Failed to write the journal record!")
+		return
+	}
+	jr.count("orphans", len(report.orphans))
+	jr.count("leftover_lists", len(report.leftovers))
+	jr.count("changes", batch.Len())
+	err = db.Write(batch, nil)
+	jr.finish(err)
+	if saveErr := jr.save(db); saveErr != nil {
+		logger.WithError(saveErr).Warn("Failed to write the journal record!")
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to write the changes into db!")
+		return
+	}
+	fmt.Println("The number of written changes is ", batch.Len())
+}
+
+//compare everything reachable from the heads with all the utxo records
+func findOrphans(state *utxoState) orphanReport {
+	report := orphanReport{broken: map[string]error{}}
+	reachable := map[string]bool{}
+	for pubkey, head := range state.heads {
+		keys, err := walkUtxoChain(state.records, head)
+		if err != nil {
+			report.broken[pubkey] = err
+		}
+		for _, key := range keys {
+			reachable[key] = true
+		}
+		if _, ok := state.oldLists[pubkey]; ok {
+			report.leftovers = append(report.leftovers, pubkey)
+		}
+	}
+	for key := range state.records {
+		if !reachable[key] {
+			report.orphans = append(report.orphans, key)
+		}
+	}
+	sort.Strings(report.orphans)
+	sort.Strings(report.leftovers)
+	return report
+}
+
+func printOrphanReport(state *utxoState, report orphanReport) {
+	fmt.Println("The number of utxos is ", len(state.records))
+	fmt.Println("The number of pubkey heads is ", len(state.heads))
+	fmt.Println("The number of orphan utxos is ", len(report.orphans))
+	for _, key := range report.orphans {
+		record := state.records[key]
+		fmt.Printf("  %s pubkey=%s amount=%s\n", utxoKeyString([]byte(key)), record.PubKeyHash.String(), record.Value.String())
+	}
+	fmt.Println("The number of leftover v0.3 utxo lists is ", len(report.leftovers))
+	for _, pubkey := range report.leftovers {
+		fmt.Println("  pubkey", pubkey)
+	}
+	if len(state.oldLists) > len(report.leftovers) && len(state.heads) > 0 {
+		fmt.Println("The number of unconverted v0.3 utxo lists is ", len(state.oldLists)-len(report.leftovers))
+	}
+	var brokenPubkeys []string
+	for pubkey := range report.broken {
+		brokenPubkeys = append(brokenPubkeys, pubkey)
+	}
+	sort.Strings(brokenPubkeys)
+	for _, pubkey := range brokenPubkeys {
+		logger.WithError(report.broken[pubkey]).Warnf("Chain of pubkey %s is broken", pubkey)
+	}
+}
+
+//put each orphan utxo at the head of the chain of its pubkey, as AddUtxos does with the newest utxo.
+//Orphans of broken chains are skipped, since their chain has to be repaired first
+func relinkOrphans(state *utxoState, report orphanReport, batch *leveldb.Batch) (int, error) {
+	dirtyRecords := map[string]*utxo.UTXO{}
+	dirtyHeads := map[string]*utxoHead{}
+	relinked := 0
+	for _, key := range report.orphans {
+		record := state.records[key]
+		pubkey := record.PubKeyHash.String()
+		if _, ok := report.broken[pubkey]; ok {
+			fmt.Printf("Skip orphan %s, the chain of pubkey %s is broken\n", utxoKeyString([]byte(key)), pubkey)
+			continue
+		}
+		head, ok := state.heads[pubkey]
+		if !ok {
+			head = &utxoHead{pubkey: pubkey}
+			state.heads[pubkey] = head
+		}
+		if len(head.lastKey) != 0 {
+			last := state.records[string(head.lastKey)]
+			last.PrevUtxoKey = []byte(key)
+			dirtyRecords[string(head.lastKey)] = last
+		}
+		record.PrevUtxoKey = nil
+		record.NextUtxoKey = head.lastKey
+		dirtyRecords[key] = record
+		head.lastKey = []byte(key)
+		dirtyHeads[pubkey] = head
+		relinked++
+	}
+
+	for key, record := range dirtyRecords {
+		value, err := encodeUtxoRecord(record)
+		if err != nil {
+			return 0, err
+		}
+		batch.Put([]byte(key), value)
+	}
+	for pubkey, head := range dirtyHeads {
+		value, err := head.encode()
+		if err != nil {
+			return 0, err
+		}
+		batch.Put([]byte(pubkey), value)
+	}
+	return relinked, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/dappley/go-dappley/common"
+	"github.com/dappley/go-dappley/core/account"
+	"github.com/dappley/go-dappley/core/transactionbase"
+	"github.com/dappley/go-dappley/core/utxo"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+//the v0.5 utxos of a v0.3 list of n utxos, converted and linked as the upgrade does in AddUtxos.
+//The returned keys are in chain order, from the head, which is the first utxo of the v0.3 list
+func testUtxoChain(t *testing.T, owner byte, n int) (*UTXOTxNew, []string) {
+	pubKeyHash := append([]byte{0x5A}, bytes.Repeat([]byte{owner}, 20)...)
+	utxotxold := NewUTXOTxOld()
+	for i := 0; i < n; i++ {
+		utxotxold.PutUtxo(&OldUTXO{
+			TXOutput: transactionbase.TXOutput{Value: common.NewAmount(uint64(i + 1)), PubKeyHash: pubKeyHash},
+			Txid:     []byte{owner, byte(i)},
+			TxIndex:  i % 2,
+		})
+	}
+	utxotx, err := utxotxold.ConvertUtxotx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastUtxoKey, err := linkUtxos(utxotx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(lastUtxoKey) != utxotxold.Key[0] {
+		t.Fatalf("head is %x, expected the first utxo of the v0.3 list %x", lastUtxoKey, utxotxold.Key[0])
+	}
+	return utxotx, utxotxold.Key
+}
+
+//a state with copies of the linked utxos and a head for each chain
+func newTestState(chains ...*UTXOTxNew) *utxoState {
+	state := &utxoState{
+		records:  map[string]*utxo.UTXO{},
+		heads:    map[string]*utxoHead{},
+		oldLists: map[string][]byte{},
+	}
+	for _, utxotx := range chains {
+		for i, key := range utxotx.Key {
+			record := *utxotx.UTXO[i]
+			state.records[key] = &record
+		}
+		last := utxotx.UTXO[len(utxotx.UTXO)-1]
+		pubkey := last.PubKeyHash.String()
+		state.heads[pubkey] = &utxoHead{pubkey: pubkey, lastKey: []byte(last.GetUTXOKey())}
+	}
+	return state
+}
+
+func testPubkey(owner byte) string {
+	return account.PubKeyHash(append([]byte{0x5A}, bytes.Repeat([]byte{owner}, 20)...)).String()
+}
+
+func TestFindOrphans(t *testing.T) {
+	chainA, keysA := testUtxoChain(t, 0x01, 4)
+	chainB, _ := testUtxoChain(t, 0x02, 3)
+	pubkeyA := testPubkey(0x01)
+
+	tests := []struct {
+		name      string
+		corrupt   func(state *utxoState)
+		orphans   []string
+		leftovers []string
+		brokenErr error
+	}{
+		{
+			name:    "consistent chains",
+			corrupt: func(state *utxoState) {},
+		},
+		{
+			name: "chain cut after its second utxo",
+			corrupt: func(state *utxoState) {
+				state.records[keysA[1]].NextUtxoKey = nil
+			},
+			orphans: sortedKeys(keysA[2:]),
+		},
+		{
+			name: "head points to a missing utxo",
+			corrupt: func(state *utxoState) {
+				state.heads[pubkeyA].lastKey = []byte("missing_0")
+			},
+			orphans:   sortedKeys(keysA),
+			brokenErr: ErrUtxoMissing,
+		},
+		{
+			name: "chain with a cycle",
+			corrupt: func(state *utxoState) {
+				state.records[keysA[3]].NextUtxoKey = []byte(keysA[1])
+			},
+			brokenErr: ErrUtxoCycle,
+		},
+		{
+			name: "utxos without a head",
+			corrupt: func(state *utxoState) {
+				delete(state.heads, pubkeyA)
+			},
+			orphans: sortedKeys(keysA),
+		},
+		{
+			name: "v0.3 list left next to the converted chain",
+			corrupt: func(state *utxoState) {
+				state.oldLists[pubkeyA] = []byte(pubkeyA)
+			},
+			leftovers: []string{pubkeyA},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newTestState(chainA, chainB)
+			tt.corrupt(state)
+			report := findOrphans(state)
+			if !equalKeys(report.orphans, tt.orphans) {
+				t.Errorf("orphans = %x, want %x", report.orphans, tt.orphans)
+			}
+			if !equalKeys(report.leftovers, tt.leftovers) {
+				t.Errorf("leftovers = %v, want %v", report.leftovers, tt.leftovers)
+			}
+			if tt.brokenErr == nil && len(report.broken) > 0 {
+				t.Errorf("broken chains = %v, want none", report.broken)
+			}
+			if tt.brokenErr != nil && !errors.Is(report.broken[pubkeyA], tt.brokenErr) {
+				t.Errorf("chain of pubkey A is broken with %v, want %v", report.broken[pubkeyA], tt.brokenErr)
+			}
+			if _, ok := report.broken[testPubkey(0x02)]; ok {
+				t.Errorf("chain of pubkey B is broken: %v", report.broken[testPubkey(0x02)])
+			}
+		})
+	}
+}
+
+//relinked orphans become the newest utxos of their chain, as AddUtxos links a new utxo
+func TestRelinkOrphans(t *testing.T) {
+	chain, keys := testUtxoChain(t, 0x01, 4)
+	pubkey := testPubkey(0x01)
+	state := newTestState(chain)
+	state.records[keys[1]].NextUtxoKey = nil
+
+	report := findOrphans(state)
+	batch := new(leveldb.Batch)
+	relinked, err := relinkOrphans(state, report, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if relinked != 2 {
+		t.Fatalf("relinked %d orphans, want 2", relinked)
+	}
+	if report := findOrphans(state); len(report.orphans) != 0 || len(report.broken) != 0 {
+		t.Fatalf("after relinking, orphans = %x, broken = %v", report.orphans, report.broken)
+	}
+	chainKeys, err := walkUtxoChain(state.records, state.heads[pubkey])
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the orphans are put at the head in key order, so the last one relinked is the head
+	want := append(append(reverseKeys(sortedKeys(keys[2:])), keys[0]), keys[1])
+	if !reflect.DeepEqual(chainKeys, want) {
+		t.Errorf("chain = %x, want %x", chainKeys, want)
+	}
+	for i, key := range chainKeys {
+		var prev []byte
+		if i > 0 {
+			prev = []byte(chainKeys[i-1])
+		}
+		if !bytes.Equal(state.records[key].PrevUtxoKey, prev) {
+			t.Errorf("prev of %x = %x, want %x", key, state.records[key].PrevUtxoKey, prev)
+		}
+	}
+}
+
+func sortedKeys(keys []string) []string {
+	sorted := append([]string{}, keys...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func reverseKeys(keys []string) []string {
+	reversed := make([]string, len(keys))
+	for i, key := range keys {
+		reversed[len(keys)-1-i] = key
+	}
+	return reversed
+}
+
+func equalKeys(got []string, want []string) bool {
+	if len(got) == 0 && len(want) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(got, want)
+}
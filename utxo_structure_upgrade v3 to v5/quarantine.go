@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dappley/go-dappley/storage"
+	logger "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	ErrNoQuarantineKey     = errors.New("no quarantined key given, use -key or -all")
+	ErrQuarantineNotFound  = errors.New("quarantined record not found")
+	ErrQuarantineKeyExists = errors.New("the original key holds a value again")
+)
+
+//reserved key prefix of the quarantined records, followed by the original key
+var quarantinePrefix = []byte("quarantine_")
+
+//a record that could not be converted, moved out of the way with its original bytes
+type quarantineRecord struct {
+	//hex of the original key
+	Key         string    `json:"key"`
+	Value       []byte    `json:"value"`
+	Reason      string    `json:"reason"`
+	Quarantined time.Time `json:"quarantined"`
+}
+
+func newQuarantineRecord(key []byte, value []byte, reason error) quarantineRecord {
+	return quarantineRecord{
+		Key:         hex.EncodeToString(key),
+		Value:       append([]byte{}, value...),
+		Reason:      reason.Error(),
+		Quarantined: time.Now().UTC(),
+	}
+}
+
+func quarantineKey(key []byte) []byte {
+	return append(append([]byte{}, quarantinePrefix...), key...)
+}
+
+//put the record under the quarantine prefix and delete the original key, in the batch of db if it is
+//in batch mode
+func quarantineRecordToDB(db storage.Storage, record quarantineRecord) error {
+	key, err := hex.DecodeString(record.Key)
+	if err != nil {
+		return err
+	}
+	rawBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = db.Put(quarantineKey(key), rawBytes)
+	if err != nil {
+		return err
+	}
+	return db.Del(key)
+}
+
+func printQuarantineReport(dbfilename string, records []quarantineRecord) {
+	if len(records) == 0 {
+		return
+	}
+	logger.Warnf("%d utxo lists could not be converted and are quarantined", len(records))
+	for _, record := range records {
+		fmt.Printf("  %s: %s\n", record.Key, record.Reason)
+	}
+	fmt.Printf("Fix the records, then run \"./utxo_upgrade quarantine restore -file %s -key <key>\" (or -all) and the upgrade again\n", dbfilename)
+}
+
+func quarantineCmdHandler(flags cmdFlags) {
+	action := *(flags[flagAction].(*string))
+	dbfilename := *(flags[flagDatabase].(*string))
+	force := *(flags[flagForce].(*bool))
+	keyHex := *(flags[flagKey].(*string))
+	all := *(flags[flagAll].(*bool))
+
+	restore := action == "restore"
+	if restore && keyHex == "" && !all {
+		logger.WithError(ErrNoQuarantineKey).Error("Nothing to restore!")
+		return
+	}
+	if restore && keyHex != "" && all {
+		logger.WithError(ErrConflictingFlags).Error("Use either -key or -all!")
+		return
+	}
+	if !isDbExist(dbfilename) {
+		logger.WithError(ErrDBNotFound).Error("Cannot find such file in the directory!")
+		return
+	}
+	if restore {
+		lock, err := acquireDBLock(dbfilename, force)
+		if err != nil {
+			logger.WithError(err).Error("Cannot lock the database!")
+			return
+		}
+		defer lock.release()
+	}
+	db, err := leveldb.OpenFile(dbfilename, &opt.Options{ReadOnly: !restore})
+	if err != nil {
+		logger.WithError(err).Error("failed to open db!")
+		return
+	}
+	defer db.Close()
+
+	records, err := getQuarantineRecords(db)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read the quarantined records!")
+		return
+	}
+	if !restore {
+		result.Counts["quarantined"] = len(records)
+		result.Data = records
+		printQuarantineRecords(records)
+		return
+	}
+	if keyHex != "" {
+		records, err = selectQuarantineRecord(records, keyHex)
+		if err != nil {
+			logger.WithError(err).Errorf("Cannot restore %s!", keyHex)
+			return
+		}
+	}
+	if len(records) == 0 {
+		fmt.Println("There are no quarantined records")
+		return
+	}
+
+	jr := newJournal(quarantine, "", "")
+	err = jr.save(db)
+	if err != nil {
+		logger.WithError(err).Error("Failed to write the journal record!")
+		return
+	}
+	batch, err := restoreQuarantineRecords(db, records)
+	if err == nil {
+		err = db.Write(batch, nil)
+	}
+	jr.count("restored", len(records))
+	jr.finish(err)
+	if saveErr := jr.save(db); saveErr != nil {
+		logger.WithError(saveErr).Warn("Failed to write the journal record!")
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to restore the quarantined records!")
+		return
+	}
+	fmt.Println("The number of restored records is ", len(records))
+	fmt.Printf("Run \"./utxo_upgrade -file %s\" to convert them\n", dbfilename)
+}
+
+func getQuarantineRecords(db *leveldb.DB) ([]quarantineRecord, error) {
+	var records []quarantineRecord
+	iter := db.NewIterator(util.BytesPrefix(quarantinePrefix), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var record quarantineRecord
+		err := json.Unmarshal(iter.Value(), &record)
+		if err != nil {
+			return nil, fmt.Errorf("record %x: %w", iter.Key(), err)
+		}
+		records = append(records, record)
+	}
+	return records, iter.Error()
+}
+
+func selectQuarantineRecord(records []quarantineRecord, keyHex string) ([]quarantineRecord, error) {
+	for _, record := range records {
+		if record.Key == keyHex {
+			return []quarantineRecord{record}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrQuarantineNotFound, keyHex)
+}
+
+//put the original bytes back at the original keys and delete the quarantined records. A key that
+//holds a value again is not overwritten
+func restoreQuarantineRecords(db *leveldb.DB, records []quarantineRecord) (*leveldb.Batch, error) {
+	batch := new(leveldb.Batch)
+	for _, record := range records {
+		key, err := hex.DecodeString(record.Key)
+		if err != nil {
+			return nil, err
+		}
+		exists, err := db.Has(key, nil)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, fmt.Errorf("%w: %s", ErrQuarantineKeyExists, record.Key)
+		}
+		batch.Put(key, record.Value)
+		batch.Delete(quarantineKey(key))
+	}
+	return batch, nil
+}
+
+func printQuarantineRecords(records []quarantineRecord) {
+	if len(records) == 0 {
+		fmt.Println("There are no quarantined records")
+		return
+	}
+	for _, record := range records {
+		fmt.Printf("%s  %s  %d bytes\n  reason: %s\n", record.Key, record.Quarantined.Format(time.RFC3339),
+			len(record.Value), record.Reason)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/dappley/go-dappley/core/account"
+	logger "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var (
+	ErrNoAddress      = errors.New("no address given, use -address or -all")
+	ErrInvalidAddress = errors.New("invalid address or pubkey hash")
+)
+
+//links of a utxo before the repair
+type utxoLinks struct {
+	prev []byte
+	next []byte
+}
+
+func repairCmdHandler(flags cmdFlags) {
+	dbfilename := *(flags[flagDatabase].(*string))
+	force := *(flags[flagForce].(*bool))
+	address := *(flags[flagAddress].(*string))
+	all := *(flags[flagAll].(*bool))
+	//a read-only database can only be diffed
+	dryRun := *(flags[flagDryRun].(*bool)) || *(flags[flagReadOnly].(*bool))
+
+	if address == "" && !all {
+		logger.WithError(ErrNoAddress).Error("Nothing to repair!")
+		return
+	}
+	if address != "" && all {
+		logger.WithError(ErrConflictingFlags).Error("Use either -address or -all!")
+		return
+	}
+	var pubkeyFilter string
+	if address != "" {
+		pubKeyHash, err := parsePubKeyHash(address)
+		if err != nil {
+			logger.WithError(err).Error("Cannot parse the address!")
+			return
+		}
+		pubkeyFilter = pubKeyHash.String()
+	}
+	if !isDbExist(dbfilename) {
+		logger.WithError(ErrDBNotFound).Error("Cannot find such file in the directory!")
+		return
+	}
+	if !dryRun {
+		lock, err := acquireDBLock(dbfilename, force)
+		if err != nil {
+			logger.WithError(err).Error("Cannot lock the database!")
+			return
+		}
+		defer lock.release()
+	}
+	db, err := leveldb.OpenFile(dbfilename, &opt.Options{ReadOnly: dryRun})
+	if err != nil {
+		logger.WithError(err).Error("failed to open db!")
+		return
+	}
+	defer db.Close()
+
+	state, err := loadUtxoState(db)
+	if err != nil {
+		logger.WithError(err).Error("Iter error!")
+		return
+	}
+	recordsByPubkey := groupUtxosByPubkey(state)
+	var pubkeys []string
+	if all {
+		for pubkey := range recordsByPubkey {
+			pubkeys = append(pubkeys, pubkey)
+		}
+		for pubkey := range state.heads {
+			if _, ok := recordsByPubkey[pubkey]; !ok {
+				pubkeys = append(pubkeys, pubkey)
+			}
+		}
+		sort.Strings(pubkeys)
+	} else {
+		pubkeys = []string{pubkeyFilter}
+	}
+
+	batch := new(leveldb.Batch)
+	repaired := 0
+	for _, pubkey := range pubkeys {
+		diff, err := repairUtxoChain(state, pubkey, recordsByPubkey[pubkey], batch)
+		if err != nil {
+			logger.WithError(err).Errorf("Failed to repair the chain of pubkey %s", pubkey)
+			return
+		}
+		if len(diff) == 0 {
+			if all {
+				continue
+			}
+			if _, ok := state.heads[pubkey]; !ok && len(recordsByPubkey[pubkey]) == 0 {
+				fmt.Printf("Pubkey %s has no utxos and no head, nothing to repair\n", pubkey)
+				continue
+			}
+			fmt.Printf("The chain of pubkey %s is consistent, nothing to repair\n", pubkey)
+			continue
+		}
+		repaired++
+		fmt.Printf("Pubkey %s:\n", pubkey)
+		for _, line := range diff {
+			fmt.Println(" ", line)
+		}
+	}
+	fmt.Println("The number of repaired chains is ", repaired)
+	result.Counts["repaired_chains"] = repaired
+	if dryRun && repaired > 0 {
+		setExitCode(exitCorruption)
+	}
+	if dryRun || batch.Len() == 0 {
+		return
+	}
+	jr := newJournal(repair, "", "")
+	err = jr.save(db)
+	if err != nil {
+		logger.WithError(err).Error("Failed to write the journal record!")
+		return
+	}
+	jr.count("repaired_chains", repaired)
+	jr.count("changes", batch.Len())
+	err = db.Write(batch, nil)
+	jr.finish(err)
+	if saveErr := jr.save(db); saveErr != nil {
+		logger.WithError(saveErr).Warn("Failed to write the journal record!")
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to write the repaired chains into db!")
+		return
+	}
+}
+
+//the address can be a dappley address or the hex string of a pubkey hash
+func parsePubKeyHash(address string) (account.PubKeyHash, error) {
+	if pubKeyHash, err := hex.DecodeString(address); err == nil && len(pubKeyHash) != 0 {
+		return account.PubKeyHash(pubKeyHash), nil
+	}
+	pubKeyHash, ok := account.GeneratePubKeyHashByAddress(account.NewAddress(address))
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAddress, address)
+	}
+	return pubKeyHash, nil
+}
+
+func groupUtxosByPubkey(state *utxoState) map[string][]string {
+	recordsByPubkey := map[string][]string{}
+	for key, record := range state.records {
+		pubkey := record.PubKeyHash.String()
+		recordsByPubkey[pubkey] = append(recordsByPubkey[pubkey], key)
+	}
+	return recordsByPubkey
+}
+
+//rebuild the chain of a pubkey from all its utxo records, put the changes into the batch and
+//return the before/after diff
+func repairUtxoChain(state *utxoState, pubkey string, keys []string, batch *leveldb.Batch) ([]string, error) {
+	head, headExist := state.heads[pubkey]
+	//a pubkey without utxos and without a head has no chain
+	if !headExist && len(keys) == 0 {
+		return nil, nil
+	}
+	order := recoverChainOrder(state, pubkey, keys)
+	before := map[string]utxoLinks{}
+	newUTXOTx := NewUTXOTxNew()
+	//AddUtxos takes the oldest utxo first and makes the last one the head
+	for i := len(order) - 1; i >= 0; i-- {
+		record := state.records[order[i]]
+		before[order[i]] = utxoLinks{prev: record.PrevUtxoKey, next: record.NextUtxoKey}
+		newUTXOTx.Key = append(newUTXOTx.Key, order[i])
+		newUTXOTx.UTXO = append(newUTXOTx.UTXO, record)
+	}
+	lastUtxoKey, err := linkUtxos(&newUTXOTx)
+	if err != nil {
+		return nil, err
+	}
+
+	var diff []string
+	if !headExist || !bytes.Equal(head.lastKey, lastUtxoKey) {
+		if headExist {
+			diff = append(diff, "- head "+utxoKeyString(head.lastKey))
+		}
+		if len(lastUtxoKey) == 0 {
+			//only reached with a head, a pubkey without both returned above
+			diff = append(diff, "+ head (deleted)")
+			batch.Delete([]byte(pubkey))
+		} else {
+			diff = append(diff, "+ head "+utxoKeyString(lastUtxoKey))
+			if !headExist {
+				head = &utxoHead{pubkey: pubkey}
+				state.heads[pubkey] = head
+			}
+			head.lastKey = lastUtxoKey
+			value, err := head.encode()
+			if err != nil {
+				return nil, err
+			}
+			batch.Put([]byte(pubkey), value)
+		}
+	}
+	for _, key := range order {
+		record := state.records[key]
+		old := before[key]
+		if bytes.Equal(old.prev, record.PrevUtxoKey) && bytes.Equal(old.next, record.NextUtxoKey) {
+			continue
+		}
+		diff = append(diff, fmt.Sprintf("- %s prev=%s next=%s", utxoKeyString([]byte(key)), linkString(old.prev), linkString(old.next)))
+		diff = append(diff, fmt.Sprintf("+ %s prev=%s next=%s", utxoKeyString([]byte(key)), linkString(record.PrevUtxoKey), linkString(record.NextUtxoKey)))
+		value, err := encodeUtxoRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		batch.Put([]byte(key), value)
+	}
+	return diff, nil
+}
+
+//order the utxos of a pubkey from the newest to the oldest. The part of the chain that is reachable
+//from the head keeps its order, the remaining utxos follow it segment by segment
+func recoverChainOrder(state *utxoState, pubkey string, keys []string) []string {
+	remaining := map[string]bool{}
+	for _, key := range keys {
+		remaining[key] = true
+	}
+	//follow NextUtxoKey from start over the remaining utxos of this pubkey
+	walk := func(start string) []string {
+		var segment []string
+		for key := start; remaining[key]; key = string(state.records[key].NextUtxoKey) {
+			delete(remaining, key)
+			segment = append(segment, key)
+		}
+		return segment
+	}
+
+	var headSegment []string
+	if head, ok := state.heads[pubkey]; ok {
+		headSegment = walk(string(head.lastKey))
+	}
+	pointedTo := map[string]bool{}
+	for key := range remaining {
+		pointedTo[string(state.records[key].NextUtxoKey)] = true
+	}
+	var starts []string
+	for key := range remaining {
+		if !pointedTo[key] {
+			starts = append(starts, key)
+		}
+	}
+	sort.Strings(starts)
+	order := headSegment
+	for _, start := range starts {
+		order = append(order, walk(start)...)
+	}
+	//what is left are cycles, each one is cut at its smallest key
+	var rest []string
+	for key := range remaining {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		order = append(order, walk(key)...)
+	}
+	return order
+}
+
+func linkString(key []byte) string {
+	if len(key) == 0 {
+		return "nil"
+	}
+	return utxoKeyString(key)
+}
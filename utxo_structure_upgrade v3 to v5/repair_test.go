@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+//a repaired chain must be linked exactly as AddUtxos links the v0.3 list it was converted from
+func TestRepairUtxoChain(t *testing.T) {
+	chainA, keysA := testUtxoChain(t, 0x01, 5)
+	chainB, _ := testUtxoChain(t, 0x02, 3)
+	pubkeyA := testPubkey(0x01)
+
+	tests := []struct {
+		name    string
+		pubkey  string
+		corrupt func(state *utxoState)
+		//number of changed utxos and heads
+		changes int
+		//whether the chain of the pubkey is linked as AddUtxos links it after the repair
+		linked bool
+	}{
+		{
+			name:    "consistent chain",
+			pubkey:  pubkeyA,
+			corrupt: func(state *utxoState) {},
+			linked:  true,
+		},
+		{
+			name:   "head is missing",
+			pubkey: pubkeyA,
+			corrupt: func(state *utxoState) {
+				delete(state.heads, pubkeyA)
+			},
+			changes: 1,
+			linked:  true,
+		},
+		{
+			name:   "chain cut in the middle",
+			pubkey: pubkeyA,
+			corrupt: func(state *utxoState) {
+				state.records[keysA[2]].NextUtxoKey = nil
+			},
+			changes: 1,
+			linked:  true,
+		},
+		{
+			name:   "prev links lost",
+			pubkey: pubkeyA,
+			corrupt: func(state *utxoState) {
+				for _, key := range keysA {
+					state.records[key].PrevUtxoKey = nil
+				}
+			},
+			changes: len(keysA) - 1,
+			linked:  true,
+		},
+		{
+			name:   "head points to a missing utxo",
+			pubkey: pubkeyA,
+			corrupt: func(state *utxoState) {
+				state.heads[pubkeyA].lastKey = []byte("missing_0")
+			},
+			changes: 1,
+			linked:  true,
+		},
+		{
+			name:    "pubkey without utxos and head",
+			pubkey:  testPubkey(0x03),
+			corrupt: func(state *utxoState) {},
+		},
+		{
+			name:   "head without utxos",
+			pubkey: pubkeyA,
+			corrupt: func(state *utxoState) {
+				for _, key := range keysA {
+					delete(state.records, key)
+				}
+			},
+			changes: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newTestState(chainA, chainB)
+			tt.corrupt(state)
+			batch := new(leveldb.Batch)
+			diff, err := repairUtxoChain(state, tt.pubkey, groupUtxosByPubkey(state)[tt.pubkey], batch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if batch.Len() != tt.changes {
+				t.Errorf("%d changes, want %d, diff:\n%s", batch.Len(), tt.changes, strings.Join(diff, "\n"))
+			}
+			if tt.changes == 0 && len(diff) != 0 {
+				t.Errorf("diff of an unchanged chain:\n%s", strings.Join(diff, "\n"))
+			}
+			if tt.linked {
+				checkLinkedAsAddUtxos(t, state, chainA)
+			}
+			checkLinkedAsAddUtxos(t, state, chainB)
+		})
+	}
+}
+
+//check that the chain of utxotx in the state has the links and the head that linkUtxos gave it,
+//which are what AddUtxos writes
+func checkLinkedAsAddUtxos(t *testing.T, state *utxoState, utxotx *UTXOTxNew) {
+	t.Helper()
+	last := utxotx.UTXO[len(utxotx.UTXO)-1]
+	pubkey := last.PubKeyHash.String()
+	head, ok := state.heads[pubkey]
+	if !ok || !bytes.Equal(head.lastKey, []byte(last.GetUTXOKey())) {
+		t.Errorf("head of pubkey %s is not %x", pubkey, last.GetUTXOKey())
+	}
+	for i, key := range utxotx.Key {
+		record, ok := state.records[key]
+		if !ok {
+			t.Errorf("utxo %x is missing", key)
+			continue
+		}
+		want := utxotx.UTXO[i]
+		if !bytes.Equal(record.PrevUtxoKey, want.PrevUtxoKey) || !bytes.Equal(record.NextUtxoKey, want.NextUtxoKey) {
+			t.Errorf("utxo %x: prev=%x next=%x, AddUtxos links prev=%x next=%x",
+				key, record.PrevUtxoKey, record.NextUtxoKey, want.PrevUtxoKey, want.NextUtxoKey)
+		}
+	}
+}
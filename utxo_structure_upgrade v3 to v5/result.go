@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+var ErrDBNotFound = errors.New("database not found")
+
+//exit codes of the tool
+const (
+	exitOK           = 0
+	exitFailure      = 1
+	exitInvalidInput = 2
+	exitDBNotFound   = 3
+	exitCorruption   = 4
+	exitPartial      = 5
+)
+
+//values of the -output flag, which every command has
+const (
+	flagOutput = "output"
+	outputText = "text"
+	outputJSON = "json"
+)
+
+//status of a command result
+const (
+	statusOK      = "ok"
+	statusFailed  = "failed"
+	statusPartial = "partial"
+)
+
+var outputPars = flagPars{flagOutput, outputText, valueTypeString, "output format: text, or json to print one result object on stdout and the progress on stderr"}
+
+//the result of the command, printed as one json object with -output json
+type cmdResult struct {
+	Command  string         `json:"command"`
+	Status   string         `json:"status"`
+	ExitCode int            `json:"exit_code"`
+	Counts   map[string]int `json:"counts"`
+	Errors   []string       `json:"errors"`
+	Warnings []string       `json:"warnings"`
+	Data     interface{}    `json:"data,omitempty"`
+}
+
+var result = &cmdResult{Counts: map[string]int{}, Errors: []string{}, Warnings: []string{}}
+
+//set by -output json, the text output goes to stderr and stdout only gets the result
+var jsonOutput bool
+
+//collects the errors and warnings logged by the commands into the result
+type resultHook struct{}
+
+func (resultHook) Levels() []logger.Level {
+	return []logger.Level{logger.ErrorLevel, logger.WarnLevel}
+}
+
+func (resultHook) Fire(entry *logger.Entry) error {
+	message := entry.Message
+	err, _ := entry.Data[logger.ErrorKey].(error)
+	if err != nil {
+		message += " " + err.Error()
+	}
+	if entry.Level == logger.WarnLevel {
+		result.Warnings = append(result.Warnings, message)
+		return nil
+	}
+	result.Errors = append(result.Errors, message)
+	setExitCode(exitCodeOf(err))
+	return nil
+}
+
+//the exit code of an error, the first error of a command decides the exit code
+func exitCodeOf(err error) int {
+	switch {
+	case err == nil:
+		return exitFailure
+	case errors.Is(err, ErrDBNotFound):
+		return exitDBNotFound
+	case errors.Is(err, ErrConflictingFlags), errors.Is(err, ErrNoAddress), errors.Is(err, ErrInvalidAddress),
+		errors.Is(err, ErrNoRetentionPolicy), errors.Is(err, ErrBackupNotFound), errors.Is(err, ErrNoQuarantineKey),
+		errors.Is(err, ErrQuarantineNotFound):
+		return exitInvalidInput
+	case errors.Is(err, ErrUtxoMissing), errors.Is(err, ErrUtxoCycle), errors.Is(err, ErrBlockNotFound):
+		return exitCorruption
+	}
+	return exitFailure
+}
+
+//set the exit code unless an earlier error set it
+func setExitCode(code int) {
+	if result.ExitCode == exitOK {
+		result.ExitCode = code
+	}
+}
+
+//fill in the status, print the result object with -output json and return the exit code
+func finishResult(command string, stdout *os.File) int {
+	result.Command = command
+	switch result.ExitCode {
+	case exitOK:
+		result.Status = statusOK
+	case exitPartial:
+		result.Status = statusPartial
+	default:
+		result.Status = statusFailed
+	}
+	if jsonOutput {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(result)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error: Failed to print the result!", err)
+			return exitFailure
+		}
+	}
+	return result.ExitCode
+}
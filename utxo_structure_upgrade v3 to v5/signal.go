@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var ErrInterrupted = errors.New("interrupted")
+
+//exit code of a forced exit, the usual code of a process killed by SIGINT
+const forcedExitCode = 130
+
+//the returned context is canceled by the first SIGINT or SIGTERM, so the upgrade stops after the utxo
+//list it is converting. A second signal exits immediately. stop restores the default signal handling
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\nInterrupted, stopping after the current batch. Interrupt again to exit immediately")
+		cancel()
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Exit without finishing the current batch, the tool lock file is left behind")
+		os.Exit(forcedExitCode)
+	}()
+	stop = func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+	return ctx, stop
+}
+
+//ErrInterrupted if ctx is canceled
+func checkInterrupted(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	return nil
+}
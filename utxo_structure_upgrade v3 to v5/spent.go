@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+
+	oldutxopb "github.com/dappley/go-dappley/tool/utxo_structure_upgrade/oldpb"
+	"github.com/dappley/go-dappley/util"
+	"github.com/golang/protobuf/proto"
+	logger "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+//a stored utxo that a transaction in the blocks spends
+type spentUtxo struct {
+	//schemaV3 or schemaV5
+	Schema  string `json:"schema"`
+	Pubkey  string `json:"pubkey"`
+	Key     string `json:"key"`
+	SpentBy string `json:"spent_by"`
+	Height  uint64 `json:"height"`
+}
+
+//the input that spends a utxo key
+type utxoSpend struct {
+	txid   []byte
+	height uint64
+}
+
+func spentCheckCmdHandler(flags cmdFlags) {
+	dbfilename := *(flags[flagDatabase].(*string))
+	force := *(flags[flagForce].(*bool))
+	deleteSpent := *(flags[flagDelete].(*bool))
+	readOnly := *(flags[flagReadOnly].(*bool))
+
+	if readOnly && deleteSpent {
+		logger.WithError(ErrConflictingFlags).Error("-readonly cannot be used with -delete!")
+		return
+	}
+	if !isDbExist(dbfilename) {
+		logger.WithError(ErrDBNotFound).Error("Cannot find such file in the directory!")
+		return
+	}
+	if deleteSpent {
+		lock, err := acquireDBLock(dbfilename, force)
+		if err != nil {
+			logger.WithError(err).Error("Cannot lock the database!")
+			return
+		}
+		defer lock.release()
+	}
+	//the report alone only reads the database
+	db, err := leveldb.OpenFile(dbfilename, &opt.Options{ReadOnly: !deleteSpent})
+	if err != nil {
+		logger.WithError(err).Error("failed to open db!")
+		return
+	}
+	defer db.Close()
+
+	state, err := loadUtxoState(db)
+	if err != nil {
+		logger.WithError(err).Error("Iter error!")
+		return
+	}
+	oldLists, err := loadOldUtxoLists(db, state)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read the v0.3 utxo lists!")
+		return
+	}
+	//utxo key -> pubkey of the v0.3 utxos
+	oldUtxos := map[string]string{}
+	for pubkey, utxotxold := range oldLists {
+		for _, key := range utxotxold.Key {
+			oldUtxos[key] = pubkey
+		}
+	}
+
+	ctx, stop := interruptContext()
+	spends, err := findSpends(ctx, db, func(key string) bool {
+		_, isOld := oldUtxos[key]
+		_, isNew := state.records[key]
+		return isOld || isNew
+	})
+	stop()
+	if err != nil {
+		logger.WithError(err).Error("Failed to replay the inputs of the blocks, nothing is changed")
+		return
+	}
+
+	var spent []spentUtxo
+	for key, spend := range spends {
+		if pubkey, ok := oldUtxos[key]; ok {
+			spent = append(spent, newSpentUtxo(schemaV3, pubkey, key, spend))
+		}
+		if record, ok := state.records[key]; ok {
+			spent = append(spent, newSpentUtxo(schemaV5, record.PubKeyHash.String(), key, spend))
+		}
+	}
+	sort.Slice(spent, func(i, j int) bool {
+		if spent[i].Height != spent[j].Height {
+			return spent[i].Height < spent[j].Height
+		}
+		return spent[i].Key < spent[j].Key
+	})
+	printSpentUtxos(spent)
+	result.Counts["spent_utxos"] = len(spent)
+	result.Data = spent
+
+	if !deleteSpent {
+		if len(spent) > 0 {
+			setExitCode(exitCorruption)
+		}
+		return
+	}
+	if len(spent) == 0 {
+		return
+	}
+	batch := new(leveldb.Batch)
+	spentV3, spentV5, err := removeSpentUtxos(state, oldLists, spends, batch)
+	if err != nil {
+		logger.WithError(err).Error("Failed to remove the spent utxos!")
+		return
+	}
+	jr := newJournal(spentCheck, "", "")
+	err = jr.save(db)
+	if err != nil {
+		logger.WithError(err).Error("Failed to write the journal record!")
+		return
+	}
+	jr.count("spent_v3", spentV3)
+	jr.count("spent_v5", spentV5)
+	jr.count("changes", batch.Len())
+	err = db.Write(batch, nil)
+	jr.finish(err)
+	if saveErr := jr.save(db); saveErr != nil {
+		logger.WithError(saveErr).Warn("Failed to write the journal record!")
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to write the changes into db!")
+		return
+	}
+	fmt.Println("The number of written changes is ", batch.Len())
+}
+
+//the contents of the v0.3 utxo lists of the state, by pubkey
+func loadOldUtxoLists(db *leveldb.DB, state *utxoState) (map[string]UTXOTxOld, error) {
+	lists := map[string]UTXOTxOld{}
+	for pubkey, key := range state.oldLists {
+		value, err := db.Get(key, nil)
+		if err != nil {
+			return nil, err
+		}
+		err, utxotxold := DeserializeUTXOTx(value)
+		if err != nil {
+			return nil, fmt.Errorf("list of %s: %w", pubkey, err)
+		}
+		lists[pubkey] = utxotxold
+	}
+	return lists, nil
+}
+
+//replay the inputs of the blocks of the height index up to the tail, and return the first input
+//that spends each utxo key that isStored accepts
+func findSpends(ctx context.Context, db *leveldb.DB, isStored func(key string) bool) (map[string]utxoSpend, error) {
+	spends := map[string]utxoSpend{}
+	tailHash, err := db.Get(tipKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("tail block hash: %w", err)
+	}
+	tail, err := getBlock(db, tailHash)
+	if err != nil {
+		return nil, err
+	}
+	for height := uint64(0); height <= tail.GetHeight(); height++ {
+		if err := checkInterrupted(ctx); err != nil {
+			return nil, err
+		}
+		hash, err := db.Get(util.UintToHex(height), nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: height %d", ErrBlockNotFound, height)
+		}
+		b, err := getBlock(db, hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range b.GetTransactions() {
+			for _, vin := range tx.Vin {
+				if len(vin.Txid) == 0 {
+					continue
+				}
+				key := string(vin.Txid) + "_" + strconv.Itoa(vin.Vout)
+				if _, ok := spends[key]; ok || !isStored(key) {
+					continue
+				}
+				spends[key] = utxoSpend{txid: tx.ID, height: height}
+			}
+		}
+	}
+	return spends, nil
+}
+
+func newSpentUtxo(schema string, pubkey string, key string, spend utxoSpend) spentUtxo {
+	return spentUtxo{
+		Schema:  schema,
+		Pubkey:  pubkey,
+		Key:     utxoKeyString([]byte(key)),
+		SpentBy: hex.EncodeToString(spend.txid),
+		Height:  spend.height,
+	}
+}
+
+//put the removal of the spent utxos into the batch. A v0.3 list is rewritten without them, or
+//deleted if nothing is left. The v0.5 chains are relinked without them like repair does
+func removeSpentUtxos(state *utxoState, oldLists map[string]UTXOTxOld, spends map[string]utxoSpend, batch *leveldb.Batch) (int, int, error) {
+	spentV3 := 0
+	for pubkey, utxotxold := range oldLists {
+		list := &oldutxopb.UtxoList{}
+		removed := 0
+		for i, key := range utxotxold.Key {
+			if _, ok := spends[key]; ok {
+				removed++
+				continue
+			}
+			list.Utxos = append(list.Utxos, utxotxold.UTXO[i].ToProto().(*oldutxopb.Utxo))
+		}
+		if removed == 0 {
+			continue
+		}
+		spentV3 += removed
+		listKey := state.oldLists[pubkey]
+		if len(list.Utxos) == 0 {
+			batch.Delete(listKey)
+			continue
+		}
+		rawBytes, err := proto.Marshal(list)
+		if err != nil {
+			return spentV3, 0, err
+		}
+		batch.Put(listKey, rawBytes)
+	}
+
+	spentV5 := 0
+	changed := map[string]bool{}
+	for key, record := range state.records {
+		if _, ok := spends[key]; !ok {
+			continue
+		}
+		changed[record.PubKeyHash.String()] = true
+		delete(state.records, key)
+		batch.Delete([]byte(key))
+		spentV5++
+	}
+	recordsByPubkey := groupUtxosByPubkey(state)
+	var pubkeys []string
+	for pubkey := range changed {
+		pubkeys = append(pubkeys, pubkey)
+	}
+	sort.Strings(pubkeys)
+	for _, pubkey := range pubkeys {
+		_, err := repairUtxoChain(state, pubkey, recordsByPubkey[pubkey], batch)
+		if err != nil {
+			return spentV3, spentV5, fmt.Errorf("chain of pubkey %s: %w", pubkey, err)
+		}
+	}
+	return spentV3, spentV5, nil
+}
+
+func printSpentUtxos(spent []spentUtxo) {
+	for _, s := range spent {
+		fmt.Printf("%s  %s  %s  spent by %s at height %d\n", s.Schema, s.Pubkey, s.Key, s.SpentBy, s.Height)
+	}
+	fmt.Println("The number of spent utxos in the utxo set is ", len(spent))
+}
@@ -3,13 +3,14 @@
 package main
 
 import(
+	"context"
+	"errors"
 	"os"
 	"flag"
 	"fmt"
 	"strconv"
 	"strings"
 	"encoding/hex"
-	copy "github.com/otiai10/copy"
 	"github.com/golang/protobuf/proto"
 	logger "github.com/sirupsen/logrus"
 	"github.com/dappley/go-dappley/util"
@@ -51,132 +52,555 @@ type UTXOTxNew struct {
 type OldUtxoIndex struct {
 	PublicKey []string
 	OldUTXOTx []UTXOTxOld
+	//raw values of the utxo lists, kept for the quarantine
+	Values    [][]byte
+	//lists of a pubkey that cannot be converted
+	Malformed []quarantineRecord
+	//identical copies of utxos that are merged
+	Merged    []duplicateUtxo
 }
 
+var (
+	ErrUtxoLengthMismatch = errors.New("the numbers of utxo keys and utxos are different")
+	ErrUtxoOwnerMismatch  = errors.New("utxo belongs to another pubkey")
+	ErrUtxoNoTxid         = errors.New("utxo has no txid")
+	ErrNoUtxo             = errors.New("utxo not found")
+)
+
+//command names
+const (
+	upgrade = "upgrade"
+	backups = "backups"
+	inspect = "inspect"
+	orphans = "orphans"
+	repair  = "repair"
+	compact = "compact"
+	journalCmd = "journal"
+	quarantine = "quarantine"
+	duplicates = "duplicates"
+	spentCheck = "spent-check"
+	help    = "help"
+)
+
+//flag names
+const (
+	flagDatabase  = "file"
+	flagForce     = "force"
+	flagBackupDir = "backup-dir"
+	flagCompress  = "compress"
+	flagKeep      = "keep"
+	flagKeepDays  = "keep-days"
+	flagName      = "name"
+	flagExamples  = "examples"
+	flagDelete    = "delete"
+	flagRelink    = "relink"
+	flagAddress   = "address"
+	flagAll       = "all"
+	flagDryRun    = "dry-run"
+	flagCompact   = "compact"
+	flagReadOnly  = "readonly"
+	flagKey       = "key"
+	flagVerifyBlocks = "verify-blocks"
+	//not a real flag, holds the action of commands that have sub actions
+	flagAction = "action"
+)
+
+//command list
+var cmdList = []string{
+	upgrade,
+	backups,
+	inspect,
+	orphans,
+	repair,
+	compact,
+	journalCmd,
+	quarantine,
+	duplicates,
+	spentCheck,
+	help,
+}
+
+//sub actions of each command, given right after the command name
+var cmdActions = map[string][]string{
+	backups: {"list", "verify", "prune"},
+	quarantine: {"list", "restore"},
+}
+
+type valueType int
+
+//type enum
+const (
+	valueTypeString = iota
+	valueTypeUint64
+	valueTypeBool
+)
+
+type flagPars struct {
+	name         string
+	defaultValue interface{}
+	valueType    valueType
+	usage        string
+}
+
+//description of each command
+var descrip = map[string]string{
+	upgrade: "upgrade the utxo structure from v0.3.0 in the database",
+	backups: "list, verify or prune the database backups",
+	inspect: "count the keys of each category (blocks, indexes, utxos of each version) in the database",
+	orphans: "find v0.5 utxos that no pubkey head reaches and v0.3 utxo lists left next to converted chains",
+	repair:  "rebuild the prev/next links and the head of the utxo chain of an address",
+	compact: "compact the whole database to drop deleted keys, and report the size and file counts before and after",
+	journalCmd: "list the journal of the tool runs that modified the database",
+	quarantine: "list the utxo lists that the upgrade could not convert, or restore them to their original keys",
+	duplicates: "list the utxos that appear more than once in a v0.3 utxo list, and whether the copies conflict",
+	spentCheck: "replay the inputs of all blocks and list the stored v0.3 and v0.5 utxos that are already spent",
+}
+
+var backupDirPars = flagPars{flagBackupDir, "./old_nodes", valueTypeString, "backup directory. Eg. ./old_nodes"}
+
+//configure input parameters/flags for each command
+var cmdFlagsMap = map[string][]flagPars{
+	upgrade: {
+		flagPars{flagDatabase, "default.db", valueTypeString, "database name. Eg. default.db"},
+		flagPars{flagForce, false, valueTypeBool, "skip the in-use checks and override a stale tool lock"},
+		backupDirPars,
+		flagPars{flagCompress, false, valueTypeBool, "store the backup as a tar.gz archive"},
+		flagPars{flagKeep, uint64(0), valueTypeUint64, "number of newest backups of the database to keep, 0 keeps all"},
+		flagPars{flagKeepDays, uint64(0), valueTypeUint64, "remove backups of the database older than this many days, 0 keeps all"},
+		flagPars{flagCompact, false, valueTypeBool, "compact the database after the upgrade"},
+		flagPars{flagVerifyBlocks, false, valueTypeBool, "check each utxo against the output of its transaction in the blocks, and quarantine the lists that do not match"},
+	},
+	backups: {
+		backupDirPars,
+		flagPars{flagDatabase, "", valueTypeString, "only the backups of this database. Eg. default.db"},
+		flagPars{flagName, "", valueTypeString, "only the backup of this name (verify)"},
+		flagPars{flagKeep, uint64(0), valueTypeUint64, "number of newest backups of each database to keep (prune)"},
+		flagPars{flagKeepDays, uint64(0), valueTypeUint64, "remove backups older than this many days (prune)"},
+	},
+	inspect: {
+		flagPars{flagDatabase, "default.db", valueTypeString, "database name. Eg. default.db"},
+		flagPars{flagExamples, uint64(3), valueTypeUint64, "number of example keys to print for each category"},
+		flagPars{flagReadOnly, true, valueTypeBool, "open the database read-only, -readonly=false opens it read-write"},
+	},
+	orphans: {
+		flagPars{flagDatabase, "default.db", valueTypeString, "database name. Eg. default.db"},
+		flagPars{flagForce, false, valueTypeBool, "skip the in-use checks and override a stale tool lock"},
+		flagPars{flagDelete, false, valueTypeBool, "delete the orphan utxos and the leftover v0.3 utxo lists"},
+		flagPars{flagRelink, false, valueTypeBool, "link the orphan utxos into the chain of their pubkey"},
+		flagPars{flagReadOnly, false, valueTypeBool, "only report and open the database read-only, -delete and -relink are refused"},
+	},
+	repair: {
+		flagPars{flagDatabase, "default.db", valueTypeString, "database name. Eg. default.db"},
+		flagPars{flagForce, false, valueTypeBool, "skip the in-use checks and override a stale tool lock"},
+		flagPars{flagAddress, "", valueTypeString, "address or pubkey hash in hex of the chain to repair"},
+		flagPars{flagAll, false, valueTypeBool, "repair the chains of all addresses"},
+		flagPars{flagDryRun, false, valueTypeBool, "only print the diff"},
+		flagPars{flagReadOnly, false, valueTypeBool, "open the database read-only, same as -dry-run"},
+	},
+	compact: {
+		flagPars{flagDatabase, "default.db", valueTypeString, "database name. Eg. default.db"},
+		flagPars{flagForce, false, valueTypeBool, "skip the in-use checks and override a stale tool lock"},
+	},
+	journalCmd: {
+		flagPars{flagDatabase, "default.db", valueTypeString, "database name. Eg. default.db"},
+		flagPars{flagReadOnly, true, valueTypeBool, "open the database read-only, -readonly=false opens it read-write"},
+	},
+	quarantine: {
+		flagPars{flagDatabase, "default.db", valueTypeString, "database name. Eg. default.db"},
+		flagPars{flagForce, false, valueTypeBool, "skip the in-use checks and override a stale tool lock (restore)"},
+		flagPars{flagKey, "", valueTypeString, "original key in hex of the utxo list to restore (restore)"},
+		flagPars{flagAll, false, valueTypeBool, "restore all quarantined utxo lists (restore)"},
+	},
+	duplicates: {
+		flagPars{flagDatabase, "default.db", valueTypeString, "database name. Eg. default.db"},
+		flagPars{flagReadOnly, true, valueTypeBool, "open the database read-only, -readonly=false opens it read-write"},
+	},
+	spentCheck: {
+		flagPars{flagDatabase, "default.db", valueTypeString, "database name. Eg. default.db"},
+		flagPars{flagForce, false, valueTypeBool, "skip the in-use checks and override a stale tool lock"},
+		flagPars{flagDelete, false, valueTypeBool, "remove the spent utxos and relink the v0.5 chains in one batch"},
+		flagPars{flagReadOnly, false, valueTypeBool, "only report and open the database read-only, -delete is refused"},
+	},
+}
+
+type commandHandler func(flags cmdFlags)
+
+//map the callback function to each command
+var cmdHandlers = map[string]commandHandler{
+	upgrade: upgradeCmdHandler,
+	backups: backupsCmdHandler,
+	inspect: inspectCmdHandler,
+	orphans: orphansCmdHandler,
+	repair:  repairCmdHandler,
+	compact: compactCmdHandler,
+	journalCmd: journalCmdHandler,
+	quarantine: quarantineCmdHandler,
+	duplicates: duplicatesCmdHandler,
+	spentCheck: spentCheckCmdHandler,
+	help:    helpCmdHandler,
+}
+
+//map key: flag name   map value: pointer to the flag value
+type cmdFlags map[string]interface{}
+
 func main(){
 	args := os.Args[1:]
-	if (len(args) < 1 || (len(args) >= 1 && args[0] != "-file")) {
+	if len(args) < 1 {
 		printUsage()
-		return
+		os.Exit(exitInvalidInput)
+	}
+	//"./utxo_upgrade -file default.db" runs the upgrade command
+	cmdName := upgrade
+	if !strings.HasPrefix(args[0], "-") {
+		cmdName = args[0]
+		args = args[1:]
+	}
+	handler, ok := cmdHandlers[cmdName]
+	if !ok {
+		fmt.Println("\nError:", cmdName, "is an invalid command")
+		printUsage()
+		os.Exit(exitInvalidInput)
 	}
-	var filePath string
-	flag.StringVar(&filePath, "file", "default.db", "default db file path")
-	flag.Parse()
+
+	flags := cmdFlags{}
+	if actions, ok := cmdActions[cmdName]; ok {
+		if len(args) < 1 || !containsString(actions, args[0]) {
+			fmt.Printf("\nError: %s needs one of the actions %s\n", cmdName, strings.Join(actions, "|"))
+			printUsage()
+			os.Exit(exitInvalidInput)
+		}
+		action := args[0]
+		flags[flagAction] = &action
+		args = args[1:]
+	}
+	fs := flag.NewFlagSet(cmdName, flag.ContinueOnError)
+	for _, par := range append(cmdFlagsMap[cmdName], outputPars) {
+		switch par.valueType {
+		case valueTypeString:
+			flags[par.name] = fs.String(par.name, par.defaultValue.(string), par.usage)
+		case valueTypeUint64:
+			flags[par.name] = fs.Uint64(par.name, par.defaultValue.(uint64), par.usage)
+		case valueTypeBool:
+			flags[par.name] = fs.Bool(par.name, par.defaultValue.(bool), par.usage)
+		}
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		os.Exit(exitInvalidInput)
+	}
+	output := *(flags[flagOutput].(*string))
+	if output != outputText && output != outputJSON {
+		fmt.Printf("\nError: invalid output format %s, use %s or %s\n", output, outputText, outputJSON)
+		os.Exit(exitInvalidInput)
+	}
+	//all text goes to stderr, so stdout only gets the result object
+	stdout := os.Stdout
+	if output == outputJSON {
+		jsonOutput = true
+		os.Stdout = os.Stderr
+	}
+	logger.AddHook(resultHook{})
+	handler(flags)
+	os.Exit(finishResult(cmdName, stdout))
+}
+
+func upgradeCmdHandler(flags cmdFlags) {
+	filePath := *(flags[flagDatabase].(*string))
+	force := *(flags[flagForce].(*bool))
+	compactAfter := *(flags[flagCompact].(*bool))
+	verifyBlocks := *(flags[flagVerifyBlocks].(*bool))
+	backupCfg := backupConfigFromFlags(flags)
 
 	isFileExist := isDbExist(filePath)
 	if !isFileExist {
-		logger.Error("Cannot find such file in the directory!")
+		logger.WithError(ErrDBNotFound).Error("Cannot find such file in the directory!")
+		return
+	}
+
+	lock, err := acquireDBLock(filePath, force)
+	if err != nil {
+		logger.WithError(err).Error("Cannot lock the database!")
 		return
 	}
+	defer lock.release()
+	//installed before anything is read, so a signal never skips the release of the lock
+	ctx, stop := interruptContext()
 
 	logger.Infof("Current database name is %s", filePath)
 
+	jr := newJournal(upgrade, schemaV3, schemaV5)
+	err = jr.saveToFile(filePath)
+	if err != nil {
+		stop()
+		logger.WithError(err).Error("Failed to write the journal record!")
+		return
+	}
+
 	fmt.Println("Start Converting......")
 
-	oldUtxoIndex := getOldUtxoIndexFromDB(filePath)
+	oldUtxoIndex, err := getOldUtxoIndexFromDB(ctx, filePath)
+	if err != nil {
+		stop()
+		jr.finish(err)
+		if saveErr := jr.saveToFile(filePath); saveErr != nil {
+			logger.WithError(saveErr).Warn("Failed to write the journal record!")
+		}
+		logger.WithError(err).Error("Failed to read the v0.3 utxo lists, nothing is converted")
+		return
+	}
 	//printInfoOfOldUtxoIndex(oldUtxoIndex)
-	ConvertAndSaveUtxoIndexToDB(filePath, oldUtxoIndex)
+	jr.count("utxo_lists", len(oldUtxoIndex.PublicKey))
+	if verifyBlocks {
+		fmt.Println("Check the utxos against the outputs in the blocks......")
+		verified, mismatches, err := verifyUtxosAgainstBlocks(ctx, filePath, &oldUtxoIndex)
+		if err != nil {
+			stop()
+			jr.finish(err)
+			if saveErr := jr.saveToFile(filePath); saveErr != nil {
+				logger.WithError(saveErr).Warn("Failed to write the journal record!")
+			}
+			logger.WithError(err).Error("Failed to check the utxos against the blocks, nothing is converted")
+			return
+		}
+		printVoutMismatches(verified, mismatches)
+		jr.count("verified_utxos", verified)
+		jr.count("vout_mismatches", len(mismatches))
+	}
+	converted, quarantined, err := ConvertAndSaveUtxoIndexToDB(ctx, filePath, oldUtxoIndex, backupCfg)
+	stop()
+	jr.count("converted_lists", converted)
+	jr.count("quarantined", len(quarantined))
+	jr.count("merged_duplicates", len(oldUtxoIndex.Merged))
+	jr.finish(err)
+	if saveErr := jr.saveToFile(filePath); saveErr != nil {
+		logger.WithError(saveErr).Warn("Failed to write the journal record!")
+	}
+	if errors.Is(err, ErrInterrupted) {
+		logger.WithError(err).Errorf("Converted %d of %d utxo lists, run \"./utxo_upgrade -file %s\" again to convert the rest",
+			converted, len(oldUtxoIndex.PublicKey), filePath)
+	}
+	printQuarantineReport(filePath, quarantined)
+	if err != nil {
+		if converted > 0 || len(quarantined) > 0 {
+			//the lists converted or quarantined before the error stay so
+			result.ExitCode = exitPartial
+		}
+		return
+	}
+	if len(quarantined) > 0 {
+		result.ExitCode = exitPartial
+	}
+	if compactAfter {
+		compactDB(filePath)
+	}
+}
+
+func helpCmdHandler(flags cmdFlags) {
+	for _, cmd := range cmdList {
+		if cmd == help {
+			continue
+		}
+		fmt.Println("\n-----------------------------------------------------------------")
+		fmt.Printf("Command: %s\n", cmd)
+		fmt.Printf("Description: %s\n", descrip[cmd])
+		fmt.Printf("Usage: ./utxo_upgrade %s", cmd)
+		if actions, ok := cmdActions[cmd]; ok {
+			fmt.Printf(" <%s>", strings.Join(actions, "|"))
+		}
+		fmt.Println()
+		for _, par := range cmdFlagsMap[cmd] {
+			fmt.Printf("  -%s: %s\n", par.name, par.usage)
+		}
+	}
+	fmt.Printf("\nEvery command also takes -%s %s|%s. Exit codes: %d ok, %d failure, %d invalid input, %d database not found, %d corruption detected, %d partial success\n",
+		flagOutput, outputText, outputJSON, exitOK, exitFailure, exitInvalidInput, exitDBNotFound, exitCorruption, exitPartial)
 }
 
 //-------------------------------core functions-------------------------------------
 
 //get old utxoindex (key = account.PubkeyHash.String(), value = old utxotx)
-func getOldUtxoIndexFromDB(dbfilename string) OldUtxoIndex {
+//ctx cancellation stops the scan with ErrInterrupted
+func getOldUtxoIndexFromDB(ctx context.Context, dbfilename string) (OldUtxoIndex, error) {
 	var publicKey []string
 	var oldUTXOTx []UTXOTxOld
+	var values [][]byte
+	var malformed []quarantineRecord
+	var merged []duplicateUtxo
 	
 	db, err := leveldb.OpenFile(dbfilename, nil)
 	if err != nil {
-		logger.Error("failed to open db!")
-		return OldUtxoIndex {
-			PublicKey: publicKey,
-			OldUTXOTx: oldUTXOTx,
-		}
+		return OldUtxoIndex{}, fmt.Errorf("failed to open db: %w", err)
 	}
 	defer db.Close()
 
 	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
 	i := 0
 	for iter.Next() {
+		if err := checkInterrupted(ctx); err != nil {
+			return OldUtxoIndex{}, err
+		}
 		curKey := iter.Key()
 		curValue := iter.Value()
 		err, utxotxold := DeserializeUTXOTx(curValue)
 		isValidUtxo := isValidUtxoKeyValue(curKey, curValue)
 
 		if err == nil && len(utxotxold.Key) != 0 && len(utxotxold.UTXO) != 0 && !isValidUtxo {
-			if isValidUtxotx(curKey, utxotxold){
+			err = validateUtxotx(curKey, utxotxold)
+			if err == nil {
+				//identical copies of a utxo are dropped, conflicting copies make the list malformed
+				var found []duplicateUtxo
+				utxotxold, found, err = mergeDuplicateUtxos(account.PubKeyHash(curKey).String(), utxotxold)
+				if err != nil {
+					malformed = append(malformed, newQuarantineRecord(curKey, curValue, err))
+					continue
+				}
+				merged = append(merged, found...)
+			}
+			if err == nil {
 				i++
 				publicKey = append(publicKey, account.PubKeyHash(curKey).String())
 				oldUTXOTx = append(oldUTXOTx, utxotxold)
+				values = append(values, append([]byte{}, curValue...))
+			} else if isUtxotxOfKey(curKey, utxotxold) {
+				//a list of this pubkey that cannot be converted, other values only parse as a list by chance
+				malformed = append(malformed, newQuarantineRecord(curKey, curValue, err))
 			}
 			//utxotxold.printInfoWithPubKey(util.Bytes2str(iter.Key()))
 		}
 	}
 
 	fmt.Println("The number of old utxotx is ", i)
-	iter.Release()
+	if len(malformed) > 0 {
+		fmt.Println("The number of malformed old utxotx is ", len(malformed))
+	}
+	for _, d := range merged {
+		fmt.Printf("Merge %d identical copies of utxo %s of pubkey %s\n", d.Count, d.Key, d.Pubkey)
+	}
 	err = iter.Error()
 	if err != nil {
-		logger.Error("Iter error!")
+		return OldUtxoIndex{}, fmt.Errorf("iter error: %w", err)
 	}
 
 	return OldUtxoIndex {
 		PublicKey: publicKey,
 		OldUTXOTx: oldUTXOTx,
-	}
+		Values:    values,
+		Malformed: malformed,
+		Merged:    merged,
+	}, nil
 }
 
-//convert old utxo index and save the results in db
-func ConvertAndSaveUtxoIndexToDB(dbfilename string, oldUtxoIndex OldUtxoIndex){
+//convert old utxo index and save the results in db, returns the number of converted utxo lists and
+//the quarantined ones. Each list is replaced in one batch, a list that cannot be converted is moved
+//under the quarantine prefix and the rest go on. ctx cancellation stops before the next list
+func ConvertAndSaveUtxoIndexToDB(ctx context.Context, dbfilename string, oldUtxoIndex OldUtxoIndex, backupCfg backupConfig) (int, []quarantineRecord, error) {
 	publicKey := oldUtxoIndex.PublicKey
 	oldUTXOTx := oldUtxoIndex.OldUTXOTx
+	values    := oldUtxoIndex.Values
 
-	if (len(publicKey) != len(oldUTXOTx)) {
-		err := "length of public key and old utxo transactions are different!"
-		panic(err)
+	if (len(publicKey) != len(oldUTXOTx) || len(publicKey) != len(values)) {
+		err := fmt.Errorf("old utxo index: %w", ErrUtxoLengthMismatch)
+		logger.WithError(err).Error("Length of public key and old utxo transactions are different!")
+		return 0, nil, err
 	}
 
-	if(len(publicKey) == 0) {
+	if(len(publicKey) == 0 && len(oldUtxoIndex.Malformed) == 0) {
 		fmt.Println("old utxo index doesn't exist in db!")
-		return
+		return 0, nil, nil
 	}
 
-	new_dbfilename := strings.TrimSuffix(dbfilename, ".db") + "_old.db"
-	err := copy.Copy(dbfilename, "./old_nodes/" + new_dbfilename)
+	backup, err := createBackup(dbfilename, backupCfg)
 	if err != nil {
-		panic(err)
+		logger.WithError(err).Error("Failed to back up the database, nothing is converted")
+		return 0, nil, err
 	}
+	fmt.Println("The database is backed up to", backup.Path)
+	applyRetention(backupCfg, backup.Database)
 
 	db := storage.OpenDatabase(dbfilename)
 	defer db.Close()
+	return convertUtxoIndexInDB(ctx, db, oldUtxoIndex)
+}
+
+//convert the lists of the old utxo index in db, each list is written in its own batch
+//ctx cancellation stops before the next list
+func convertUtxoIndexInDB(ctx context.Context, db storage.Storage, oldUtxoIndex OldUtxoIndex) (int, []quarantineRecord, error) {
+	publicKey := oldUtxoIndex.PublicKey
+	oldUTXOTx := oldUtxoIndex.OldUTXOTx
+	values    := oldUtxoIndex.Values
+
 	//newUtxoCache := utxo.NewUTXOCache(db)
+	db.EnableBatch()
+	defer db.DisableBatch()
+
+	var quarantined []quarantineRecord
+	quarantineList := func(record quarantineRecord) error {
+		err := quarantineRecordToDB(db, record)
+		if err == nil {
+			err = db.Flush()
+		}
+		if err != nil {
+			logger.WithError(err).Errorf("Failed to quarantine the utxo list %s!", record.Key)
+			return err
+		}
+		quarantined = append(quarantined, record)
+		return nil
+	}
+	for _, record := range oldUtxoIndex.Malformed {
+		if err := quarantineList(record); err != nil {
+			return 0, quarantined, err
+		}
+	}
 
 	utxo_converted := 0
 	for i := 0; i < len(publicKey); i++ {		
+		if err := checkInterrupted(ctx); err != nil {
+			fmt.Println("The number of converted utxotx is ", utxo_converted)
+			return utxo_converted, quarantined, err
+		}
 		pubkey    := publicKey[i]
 		oldutxotx := oldUTXOTx[i]
 
-		newutxotx := oldutxotx.ConvertUtxotx()
 		pubkeyhash, err := hex.DecodeString(pubkey)
 		if err != nil {
 			logger.Error("Failed to decode pubkey hash string")
-			return
+			return utxo_converted, quarantined, err
+		}
+		newutxotx, err := oldutxotx.ConvertUtxotx()
+		if err != nil {
+			if err := quarantineList(newQuarantineRecord(pubkeyhash, values[i], err)); err != nil {
+				return utxo_converted, quarantined, err
+			}
+			continue
 		}
 		err = db.Del(pubkeyhash)
 		if err != nil {
 			logger.Error("Failed to delete pubkey-utxotx pair from db")
-			return
+			return utxo_converted, quarantined, err
 		}
 		//add new utxotx into db
 		err = AddUtxos(db, newutxotx, pubkey)
+		if errors.Is(err, ErrUtxoLengthMismatch) || errors.Is(err, ErrUtxoDuplicate) {
+			//nothing but the delete of the list is in the batch yet
+			if err := quarantineList(newQuarantineRecord(pubkeyhash, values[i], err)); err != nil {
+				return utxo_converted, quarantined, err
+			}
+			continue
+		}
 		if err != nil {
 			logger.WithError(err).Error("Failed to add UTXOTx into db!")
-			return
+			return utxo_converted, quarantined, err
+		}
+		err = db.Flush()
+		if err != nil {
+			logger.WithError(err).Error("Failed to write the converted UTXOTx into db!")
+			return utxo_converted, quarantined, err
 		}
 		utxo_converted++
 	}
 	fmt.Println("The number of converted utxotx is ", utxo_converted)
-	return
+	return utxo_converted, quarantined, nil
 }
 
 //------------------------------helper functions------------------------------------
@@ -185,7 +609,22 @@ func printUsage() {
 	fmt.Println("--------------------------------------------------------------------------")
 	fmt.Println("Usage: upgrade the utxo structure from v0.3.0 in the database")
 	fmt.Println("Usage example: ./utxo_upgrade -file default.db")
-	fmt.Println("Version before update will be saved in the \"old_nodes\" folder as backup")
+	fmt.Println("The database must not be opened by a running node, use -force to override a stale tool lock")
+	fmt.Println("Version before update will be saved in the \"old_nodes\" folder as backup, use -backup-dir to change it")
+	fmt.Println("Commands:")
+	for _, cmd := range cmdList {
+		fmt.Println(" ", cmd)
+	}
+	fmt.Println("Note: Use the command 'help' to get the command usage in details")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
 
 func isDbExist(filename string) bool {
@@ -265,24 +704,39 @@ func isValidUtxoKeyValue(key []byte, value []byte) bool {
 
 //only true for valid utxotx when each utxo in the utxotx has the same pubkey as the utxotx pubkey and txid not empty
 func isValidUtxotx(key[] byte, utxotxold UTXOTxOld) bool {
+	return validateUtxotx(key, utxotxold) == nil
+}
+
+//the reason why the utxotx is not a valid utxotx of the pubkey, nil if it is
+func validateUtxotx(key[] byte, utxotxold UTXOTxOld) error {
 	pubkey := account.PubKeyHash(key).String()
 	utxotxold_key  := utxotxold.Key
 	utxotxold_utxo := utxotxold.UTXO
 	if (len(utxotxold_key) != len(utxotxold_utxo)) {
-		err := "Length of utxotxold_key and utxotxold_utxo are different!"
-		panic(err)
+		return fmt.Errorf("%w: %d keys, %d utxos", ErrUtxoLengthMismatch, len(utxotxold_key), len(utxotxold_utxo))
 	}
 	for i := 0; i < len(utxotxold_key); i++ {
 		value := utxotxold_utxo[i]
 		utxo_pubkey := hex.EncodeToString(value.PubKeyHash)
 		if strings.Compare(pubkey, utxo_pubkey) != 0 {
-			return false
+			return fmt.Errorf("%w: utxo %d of %s", ErrUtxoOwnerMismatch, i, utxo_pubkey)
 		}
 		if len(value.Txid) == 0 {
-			return false
+			return fmt.Errorf("%w: utxo %d", ErrUtxoNoTxid, i)
 		}
 	}
-	return true
+	return nil
+}
+
+//true if any utxo in the utxotx has the pubkey of the key
+func isUtxotxOfKey(key []byte, utxotxold UTXOTxOld) bool {
+	pubkey := account.PubKeyHash(key).String()
+	for _, value := range utxotxold.UTXO {
+		if value != nil && hex.EncodeToString(value.PubKeyHash) == pubkey {
+			return true
+		}
+	}
+	return false
 }
 
 func NewUTXOTxOld() UTXOTxOld {
@@ -305,28 +759,30 @@ func(outxo *OldUTXO) ConvertUtxo() *utxo.UTXO {
 	return newUTXO
 }
 
-//convert old utxotx to new utxotx, if old utxotx is empty, return empty new Utxotx
-func (utxoTxOld UTXOTxOld) ConvertUtxotx() *UTXOTxNew {
+//convert old utxotx to new utxotx, an old utxotx that is empty or whose keys and utxos do not match
+//is an error
+func (utxoTxOld UTXOTxOld) ConvertUtxotx() (*UTXOTxNew, error) {
 	NewUTXOTx := NewUTXOTxNew()
 	key  := utxoTxOld.Key
 	utxo := utxoTxOld.UTXO
 
 	if (len(key) != len(utxo)) {
-		err := "Length of key and utxo are different!"
-		panic(err)
+		return nil, fmt.Errorf("%w: %d keys, %d utxos", ErrUtxoLengthMismatch, len(key), len(utxo))
 	}
 
 	if (len(key) == 0) {
-		logger.Error("utxo not found")
-		return &NewUTXOTx
+		return nil, ErrNoUtxo
 	}
 	
 	end  := len(key) - 1
 	for i := end; i >= 0; i-- {
+		if utxo[i] == nil {
+			return nil, fmt.Errorf("%w: utxo %d is empty", ErrNoUtxo, i)
+		}
 		newutxo := utxo[i].ConvertUtxo()
 		NewUTXOTx.PutUtxo(newutxo)
 	}
-	return &NewUTXOTx
+	return &NewUTXOTx, nil
 }
 
 func putUTXOToDB(db storage.Storage, utxo *utxo.UTXO) error {
@@ -351,26 +807,29 @@ func putLastUTXOKeyToDB(db storage.Storage, pubkey string, lastUtxoKey []byte) e
 	return nil
 }
 
-func AddUtxos(db storage.Storage, utxoTx *UTXOTxNew, pubkey string) error {
+//link the utxos into a doubly linked list and return the key of the last utxo, which is the head.
+//Each utxo's NextUtxoKey points to the utxo before it and PrevUtxoKey to the one after it
+func linkUtxos(utxoTx *UTXOTxNew) ([]byte, error) {
 	var lastUtxoKey []byte
-	//var prevUtxoKey []byte
 	var prevUtxoKeys [][]byte
 	key  := utxoTx.Key
 	utxo := utxoTx.UTXO
 
 	if (len(key) != len(utxo)) {
-		err := "length of key and utxo in UTXOTxNew are different!"
-		panic(err)
+		return nil, fmt.Errorf("%w: %d keys, %d utxos", ErrUtxoLengthMismatch, len(key), len(utxo))
 	}
 
-	//lastUtxoKey := getLastUTXOKey(db, pubkey)
+	seen := map[string]bool{}
 	for i := 0; i < len(key); i++ {
+		//a utxo that is put twice would link to itself
+		if seen[key[i]] {
+			return nil, fmt.Errorf("%w: %x", ErrUtxoDuplicate, key[i])
+		}
+		seen[key[i]] = true
 		prevUtxoKeys = append(prevUtxoKeys, util.Str2bytes(key[i]))
 	}
 
-	// fmt.Println(len(key))
 	for i := 0; i < len(key); i++ {
-		KEY  := key[i]
 		UTXO := utxo[i]
 		UTXO.NextUtxoKey = lastUtxoKey
 		if i == len(key)-1 {
@@ -378,18 +837,27 @@ func AddUtxos(db storage.Storage, utxoTx *UTXOTxNew, pubkey string) error {
 		} else {
 			UTXO.PrevUtxoKey = prevUtxoKeys[i+1]
 		}
+		lastUtxoKey = prevUtxoKeys[i]
+	}
+	return lastUtxoKey, nil
+}
+
+//the utxos are linked before anything is put, so a list that cannot be linked leaves db unchanged
+func AddUtxos(db storage.Storage, utxoTx *UTXOTxNew, pubkey string) error {
+	//lastUtxoKey := getLastUTXOKey(db, pubkey)
+	lastUtxoKey, err := linkUtxos(utxoTx)
+	if err != nil {
+		return err
+	}
+
+	for _, UTXO := range utxoTx.UTXO {
 		err := putUTXOToDB(db, UTXO)
 		if err != nil {
 			return err
 		}
-		// fmt.Println("Prev: ", UTXO.PrevUtxoKey)
-		// fmt.Println("Curr: ", util.Str2bytes(KEY))
-		// fmt.Println("Next: ", UTXO.NextUtxoKey)
-		lastUtxoKey = util.Str2bytes(KEY)
 	}
-	//fmt.Println("\n\n\n")
 
-	err := putLastUTXOKeyToDB(db, pubkey, lastUtxoKey)
+	err = putLastUTXOKeyToDB(db, pubkey, lastUtxoKey)
 	if err != nil {
 		return err
 	}
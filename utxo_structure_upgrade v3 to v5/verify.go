@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/dappley/go-dappley/core/block"
+	blockpb "github.com/dappley/go-dappley/core/block/pb"
+	"github.com/dappley/go-dappley/core/transactionbase"
+	"github.com/dappley/go-dappley/util"
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var (
+	ErrUtxoVoutMismatch   = errors.New("utxo does not match the output of its transaction")
+	ErrCreatingTxNotFound = errors.New("transaction of the utxo is not in the blocks")
+	ErrBlockNotFound      = errors.New("block of the height index is not in the database")
+)
+
+//a v0.3 utxo that does not match the output that created it
+type voutMismatch struct {
+	Pubkey string `json:"pubkey"`
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+//check every utxo of the old utxo index against the output of the transaction that created it. The
+//lists with a mismatch are moved to the malformed lists, so they are quarantined and not converted
+func verifyUtxosAgainstBlocks(ctx context.Context, dbfilename string, oldUtxoIndex *OldUtxoIndex) (int, []voutMismatch, error) {
+	txids := map[string]bool{}
+	for _, utxotxold := range oldUtxoIndex.OldUTXOTx {
+		for _, u := range utxotxold.UTXO {
+			txids[string(u.Txid)] = true
+		}
+	}
+	db, err := leveldb.OpenFile(dbfilename, &opt.Options{ReadOnly: true})
+	if err != nil {
+		return 0, nil, err
+	}
+	outputs, err := getTxOutputs(ctx, db, txids)
+	db.Close()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	verified := 0
+	var mismatches []voutMismatch
+	kept := OldUtxoIndex{Malformed: oldUtxoIndex.Malformed, Merged: oldUtxoIndex.Merged}
+	for i, utxotxold := range oldUtxoIndex.OldUTXOTx {
+		pubkey := oldUtxoIndex.PublicKey[i]
+		var listErr error
+		for _, u := range utxotxold.UTXO {
+			err := checkOldUtxoOutput(u, outputs)
+			if err != nil {
+				mismatches = append(mismatches, voutMismatch{Pubkey: pubkey, Key: utxoKeyHex(u), Reason: err.Error()})
+				if listErr == nil {
+					listErr = fmt.Errorf("utxo %s: %w", utxoKeyHex(u), err)
+				}
+				continue
+			}
+			verified++
+		}
+		if listErr != nil {
+			key, err := hex.DecodeString(pubkey)
+			if err != nil {
+				return verified, mismatches, err
+			}
+			kept.Malformed = append(kept.Malformed, newQuarantineRecord(key, oldUtxoIndex.Values[i], listErr))
+			continue
+		}
+		kept.PublicKey = append(kept.PublicKey, pubkey)
+		kept.OldUTXOTx = append(kept.OldUTXOTx, utxotxold)
+		kept.Values = append(kept.Values, oldUtxoIndex.Values[i])
+	}
+	*oldUtxoIndex = kept
+	return verified, mismatches, nil
+}
+
+//the outputs of the transactions in txids, from the blocks of the height index up to the tail
+func getTxOutputs(ctx context.Context, db *leveldb.DB, txids map[string]bool) (map[string][]transactionbase.TXOutput, error) {
+	outputs := map[string][]transactionbase.TXOutput{}
+	if len(txids) == 0 {
+		return outputs, nil
+	}
+	tailHash, err := db.Get(tipKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("tail block hash: %w", err)
+	}
+	tail, err := getBlock(db, tailHash)
+	if err != nil {
+		return nil, err
+	}
+	for height := uint64(0); height <= tail.GetHeight(); height++ {
+		if err := checkInterrupted(ctx); err != nil {
+			return nil, err
+		}
+		hash, err := db.Get(util.UintToHex(height), nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: height %d", ErrBlockNotFound, height)
+		}
+		b, err := getBlock(db, hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range b.GetTransactions() {
+			if txids[string(tx.ID)] {
+				outputs[string(tx.ID)] = tx.Vout
+			}
+		}
+		if len(outputs) == len(txids) {
+			break
+		}
+	}
+	return outputs, nil
+}
+
+func getBlock(db *leveldb.DB, hash []byte) (*block.Block, error) {
+	rawBytes, err := db.Get(hash, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: hash %x", ErrBlockNotFound, hash)
+	}
+	//block.Deserialize panics on bytes that are not a block
+	blockPb := &blockpb.Block{}
+	if err := proto.Unmarshal(rawBytes, blockPb); err != nil {
+		return nil, fmt.Errorf("%w: hash %x cannot be parsed: %v", ErrBlockNotFound, hash, err)
+	}
+	b := &block.Block{}
+	b.FromProto(blockPb)
+	return b, nil
+}
+
+//the utxo must be output TxIndex of Txid with the same value, pubkey hash and contract
+func checkOldUtxoOutput(u *OldUTXO, outputs map[string][]transactionbase.TXOutput) error {
+	vouts, ok := outputs[string(u.Txid)]
+	if !ok {
+		return ErrCreatingTxNotFound
+	}
+	if u.TxIndex < 0 || u.TxIndex >= len(vouts) {
+		return fmt.Errorf("%w: the transaction has %d outputs", ErrUtxoVoutMismatch, len(vouts))
+	}
+	vout := vouts[u.TxIndex]
+	if vout.Value == nil || u.Value == nil {
+		return fmt.Errorf("%w: the value is missing", ErrUtxoVoutMismatch)
+	}
+	if vout.Value.Cmp(u.Value) != 0 {
+		return fmt.Errorf("%w: value %s, output value %s", ErrUtxoVoutMismatch, u.Value.String(), vout.Value.String())
+	}
+	if !bytes.Equal(vout.PubKeyHash, u.PubKeyHash) {
+		return fmt.Errorf("%w: pubkey hash %s, output pubkey hash %s", ErrUtxoVoutMismatch, u.PubKeyHash.String(), vout.PubKeyHash.String())
+	}
+	if vout.Contract != u.Contract {
+		return fmt.Errorf("%w: contract differs from the output", ErrUtxoVoutMismatch)
+	}
+	return nil
+}
+
+func printVoutMismatches(verified int, mismatches []voutMismatch) {
+	for _, m := range mismatches {
+		fmt.Printf("  %s  %s: %s\n", m.Pubkey, m.Key, m.Reason)
+	}
+	fmt.Printf("%d utxos match the outputs that created them, %d do not\n", verified, len(mismatches))
+}